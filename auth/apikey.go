@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"net/http"
+)
+
+// APIKeyHeader carries a static API key
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates requests by a static key sent in the X-API-Key header
+type APIKeys struct {
+	// principals by the SHA-256 of their key, so lookups don't leak the keys through timing
+	principals map[[sha256.Size]byte]*Principal
+}
+
+// NewAPIKeys returns an APIKeys accepting the keys with a Key
+func NewAPIKeys(keys []Key) *APIKeys {
+	a := &APIKeys{principals: map[[sha256.Size]byte]*Principal{}}
+	for _, key := range keys {
+		if key.Key != "" {
+			a.principals[sha256.Sum256([]byte(key.Key))] = newPrincipal(key)
+		}
+	}
+	return a
+}
+
+// Authenticate implements Authenticator
+func (a *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	principal, ok := a.principals[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return principal, nil
+}
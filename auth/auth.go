@@ -0,0 +1,79 @@
+// Package auth identifies the callers of the server and the scopes they were granted
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Scopes granted to callers
+const (
+	ScopeOrderCreate  = "order:create"
+	ScopeOrderRead    = "order:read"
+	ScopeRefundCreate = "refund:create"
+)
+
+// Scopes lists the known scopes
+var Scopes = []string{ScopeOrderCreate, ScopeOrderRead, ScopeRefundCreate}
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request has none of its credentials
+	ErrNoCredentials = errors.New("Credentials are missing")
+
+	// ErrInvalidCredentials is returned for an unknown API key or key ID
+	ErrInvalidCredentials = errors.New("Credentials are invalid")
+
+	// ErrInvalidSignature is returned when the signature doesn't match the request
+	ErrInvalidSignature = errors.New("Signature doesn't match the request")
+
+	// ErrStaleRequest is returned when the timestamp of a signed request is outside the allowed skew
+	ErrStaleRequest = errors.New("Request timestamp is too old or in the future")
+
+	// ErrReplayedRequest is returned for a signed request that was already received
+	ErrReplayedRequest = errors.New("Request was already received")
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	// KeyID identifies the key the caller authenticated with
+	KeyID string
+
+	// MerchantID is the merchant the caller acts for, empty for the default merchant
+	MerchantID string
+
+	Scopes map[string]bool
+}
+
+// Has reports whether the caller was granted scope
+func (p *Principal) Has(scope string) bool {
+	return p.Scopes[scope]
+}
+
+// Authenticator identifies the caller of a request. It returns ErrNoCredentials
+// when the request carries none of the credentials it checks.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each authenticator in turn, until one finds credentials in the request
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if err != ErrNoCredentials {
+			return principal, err
+		}
+	}
+
+	return nil, ErrNoCredentials
+}
+
+func newPrincipal(key Key) *Principal {
+	principal := &Principal{KeyID: key.ID, MerchantID: key.Merchant, Scopes: map[string]bool{}}
+	for _, scope := range key.Scopes {
+		principal.Scopes[scope] = true
+	}
+	return principal
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Key is a credential given to a caller. A key with a Key is sent as is in the X-API-Key
+// header, a key with a Secret signs requests. Callers are granted the scopes of their key.
+type Key struct {
+	ID string `json:"id"`
+
+	Key string `json:"key,omitempty"`
+
+	Secret string `json:"secret,omitempty"`
+
+	Scopes []string `json:"scopes"`
+
+	// Merchant the caller acts for, the default merchant when empty
+	Merchant string `json:"merchant,omitempty"`
+}
+
+// LoadKeys reads a JSON array of keys from the file at path
+func LoadKeys(path string) ([]Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, ValidateKeys(keys)
+}
+
+// ValidateKeys checks every key has a unique ID, a key or a secret, and known scopes
+func ValidateKeys(keys []Key) error {
+	known := map[string]bool{}
+	for _, scope := range Scopes {
+		known[scope] = true
+	}
+
+	ids := map[string]bool{}
+	for i, key := range keys {
+		if key.ID == "" {
+			return fmt.Errorf("key %d has no id", i)
+		}
+
+		if ids[key.ID] {
+			return fmt.Errorf("key %s is listed twice", key.ID)
+		}
+		ids[key.ID] = true
+
+		if key.Key == "" && key.Secret == "" {
+			return fmt.Errorf("key %s has neither a key nor a secret", key.ID)
+		}
+
+		for _, scope := range key.Scopes {
+			if !known[scope] {
+				return fmt.Errorf("key %s has unknown scope %s", key.ID, scope)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request
+const (
+	KeyIDHeader     = "X-Key-ID"
+	TimestampHeader = "X-Timestamp"
+	SignatureHeader = "X-Signature"
+)
+
+// maxSignedBody bounds the body read to check a signature
+const maxSignedBody = 1 << 20
+
+// ErrBodyTooLarge is returned when the body of a signed request is too large to check
+var ErrBodyTooLarge = errors.New("Request body is too large")
+
+// Signatures authenticates requests signed with HMAC-SHA256 by a shared secret.
+// The signature is the hex HMAC of the method, the path with its query, the Unix
+// timestamp sent in X-Timestamp and the hex SHA-256 of the body, joined with "\n".
+// A request is rejected when its timestamp is more than the skew away from the
+// server's clock, or when the same signature was received before.
+type Signatures struct {
+	keys map[string]signingKey
+
+	skew time.Duration
+
+	now func() time.Time
+
+	replays *replays
+}
+
+// replays holds the signatures received within the skew, with the time they can be forgotten
+type replays struct {
+	mu sync.Mutex
+
+	seen map[string]time.Time
+
+	lastSweep time.Time
+}
+
+type signingKey struct {
+	secret []byte
+
+	principal *Principal
+}
+
+// NewSignatures returns a Signatures accepting the keys with a Secret
+func NewSignatures(keys []Key, skew time.Duration) *Signatures {
+	return newSignatures(keys, skew, &replays{seen: map[string]time.Time{}})
+}
+
+// WithKeys returns a Signatures accepting keys instead. It shares the signatures received
+// so far, so a request accepted before the change of keys can't be replayed after it.
+func (s *Signatures) WithKeys(keys []Key, skew time.Duration) *Signatures {
+	return newSignatures(keys, skew, s.replays)
+}
+
+func newSignatures(keys []Key, skew time.Duration, replays *replays) *Signatures {
+	s := &Signatures{
+		keys:    map[string]signingKey{},
+		skew:    skew,
+		now:     time.Now,
+		replays: replays,
+	}
+
+	for _, key := range keys {
+		if key.Secret != "" {
+			s.keys[key.ID] = signingKey{secret: []byte(key.Secret), principal: newPrincipal(key)}
+		}
+	}
+
+	return s
+}
+
+// Authenticate implements Authenticator. The body of the request is read and replaced,
+// so handlers can still read it.
+func (s *Signatures) Authenticate(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(KeyIDHeader)
+	if keyID == "" {
+		return nil, ErrNoCredentials
+	}
+
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return nil, ErrStaleRequest
+	}
+
+	timestamp := time.Unix(unix, 0)
+	now := s.now()
+	if timestamp.Before(now.Add(-s.skew)) || timestamp.After(now.Add(s.skew)) {
+		return nil, ErrStaleRequest
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(body) > maxSignedBody {
+			return nil, ErrBodyTooLarge
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := Sign(key.secret, r.Method, r.URL.RequestURI(), unix, body)
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidSignature
+	}
+
+	if !s.replays.remember(keyID+":"+hex.EncodeToString(signature), timestamp.Add(s.skew), now, s.skew) {
+		return nil, ErrReplayedRequest
+	}
+
+	return key.principal, nil
+}
+
+// remember records a signature until it expires, sweeping the expired ones every skew.
+// It returns false if the signature was already recorded.
+func (r *replays) remember(signature string, expires, now time.Time, skew time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastSweep) > skew {
+		for seen, seenExpires := range r.seen {
+			if now.After(seenExpires) {
+				delete(r.seen, seen)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	if _, ok := r.seen[signature]; ok {
+		return false
+	}
+
+	r.seen[signature] = expires
+	return true
+}
+
+// Sign returns the signature of a request, see Signatures
+func Sign(secret []byte, method, requestURI string, timestamp int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	message := strings.Join([]string{
+		method,
+		requestURI,
+		strconv.FormatInt(timestamp, 10),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKeys = []Key{
+	{ID: "app", Secret: "app-secret", Scopes: []string{"order:create"}},
+	{ID: "reader", Key: "reader-key", Scopes: []string{"order:read"}},
+}
+
+// signedRequest returns a request signed with secret at timestamp, sent with body
+func signedRequest(keyID, secret string, timestamp time.Time, body string) *http.Request {
+	r := httptest.NewRequest("POST", "/v1/order?env=test", strings.NewReader(body))
+	signature := Sign([]byte(secret), r.Method, r.URL.RequestURI(), timestamp.Unix(), []byte(body))
+
+	r.Header.Set(KeyIDHeader, keyID)
+	r.Header.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	r.Header.Set(SignatureHeader, hex.EncodeToString(signature))
+	return r
+}
+
+func TestSignaturesAuthenticate(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	skew := 5 * time.Minute
+
+	tests := []struct {
+		name string
+
+		request func() *http.Request
+
+		err error
+	}{
+		{"signed", func() *http.Request { return signedRequest("app", "app-secret", now, "{}") }, nil},
+		{"within skew", func() *http.Request { return signedRequest("app", "app-secret", now.Add(-skew), "{}") }, nil},
+		{"ahead within skew", func() *http.Request { return signedRequest("app", "app-secret", now.Add(skew), "{}") }, nil},
+		{"too old", func() *http.Request { return signedRequest("app", "app-secret", now.Add(-skew-time.Second), "{}") }, ErrStaleRequest},
+		{"too far ahead", func() *http.Request { return signedRequest("app", "app-secret", now.Add(skew+time.Second), "{}") }, ErrStaleRequest},
+		{"wrong secret", func() *http.Request { return signedRequest("app", "other-secret", now, "{}") }, ErrInvalidSignature},
+		{"key without secret", func() *http.Request { return signedRequest("reader", "", now, "{}") }, ErrInvalidCredentials},
+		{"unknown key", func() *http.Request { return signedRequest("other", "app-secret", now, "{}") }, ErrInvalidCredentials},
+		{"unsigned", func() *http.Request { return httptest.NewRequest("POST", "/v1/order", nil) }, ErrNoCredentials},
+		{"altered body", func() *http.Request {
+			r := signedRequest("app", "app-secret", now, "{}")
+			r.Body = ioutil.NopCloser(strings.NewReader(`{"amount":"1"}`))
+			return r
+		}, ErrInvalidSignature},
+		{"no timestamp", func() *http.Request {
+			r := signedRequest("app", "app-secret", now, "{}")
+			r.Header.Del(TimestampHeader)
+			return r
+		}, ErrStaleRequest},
+	}
+
+	for _, test := range tests {
+		s := NewSignatures(testKeys, skew)
+		s.now = func() time.Time { return now }
+
+		principal, err := s.Authenticate(test.request())
+		if err != test.err {
+			t.Errorf("%s: Authenticate() error %v, want %v", test.name, err, test.err)
+		}
+
+		if err == nil && principal.KeyID != "app" {
+			t.Errorf("%s: Authenticate() = %+v, want key app", test.name, principal)
+		}
+	}
+}
+
+func TestSignaturesReplay(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	skew := 5 * time.Minute
+	s := NewSignatures(testKeys, skew)
+
+	tests := []struct {
+		name string
+
+		// at is when the request is received
+		at time.Duration
+
+		// reload switches to new keys before the request, like a configuration reload
+		reload bool
+
+		request *http.Request
+
+		err error
+	}{
+		{"first", 0, false, signedRequest("app", "app-secret", now, "{}"), nil},
+		{"replayed", time.Minute, false, signedRequest("app", "app-secret", now, "{}"), ErrReplayedRequest},
+		{"other body", time.Minute, false, signedRequest("app", "app-secret", now, `{"amount":"1"}`), nil},
+		{"replayed after a reload", 2 * time.Minute, true, signedRequest("app", "app-secret", now, "{}"), ErrReplayedRequest},
+		{"replayed once stale", skew + time.Second, false, signedRequest("app", "app-secret", now, "{}"), ErrStaleRequest},
+	}
+
+	for _, test := range tests {
+		if test.reload {
+			s = s.WithKeys(testKeys, skew)
+		}
+		s.now = func() time.Time { return now.Add(test.at) }
+
+		if _, err := s.Authenticate(test.request); err != test.err {
+			t.Errorf("%s: Authenticate() error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/instamojo/sample-sdk-server/auth"
+	"github.com/instamojo/sample-sdk-server/ratelimit"
+)
+
+type contextKey int
+
+// Keys of the values stored for a request in gorilla/context,
+// which is where mux v1.1 keeps the route variables too
+const (
+	// principalKey stores the authenticated caller
+	principalKey contextKey = iota
+
+	// settingsKey stores the settings the request is served with
+	settingsKey
+)
+
+// authorize lets the request through to handler if its caller was granted scope.
+// Failed authentications are counted by IP address against failures, when it isn't nil,
+// and addresses over its limit are rejected without being authenticated.
+func authorize(scope string, failures *ratelimit.Limiter, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authenticator := settingsOf(r).authenticator
+		if authenticator == nil {
+			handler(w, r)
+			return
+		}
+
+		if failures != nil {
+			if result := failures.Peek(ipKey(r)); !result.Allowed {
+				rejectLimited(w, authFailureLimit, result)
+				return
+			}
+		}
+
+		principal, err := authenticator.Authenticate(r)
+		if err != nil {
+			log.Printf("Authentication failed. Error %v", err)
+			if failures != nil {
+				failures.Allow(ipKey(r))
+			}
+			writeError(w, err)
+			return
+		}
+
+		if !principal.Has(scope) {
+			log.Printf("Key %s lacks scope %s", principal.KeyID, scope)
+			writeErrorCode(w, http.StatusForbidden, codeForbidden, "The key isn't granted the "+scope+" scope")
+			return
+		}
+
+		context.Set(r, principalKey, principal)
+		handler(w, r)
+	}
+}
+
+// principalOf returns the authenticated caller of r, nil if requests are not authenticated
+func principalOf(r *http.Request) *auth.Principal {
+	principal, _ := context.Get(r, principalKey).(*auth.Principal)
+	return principal
+}
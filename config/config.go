@@ -1,11 +1,24 @@
+// Package config loads the settings of the server
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type config struct {
+// Config holds the settings of the server
+type Config struct {
+	// Port the server listens on
+	Port string
+
 	ProdClientID string
 
 	ProdClientSecret string
@@ -14,43 +27,354 @@ type config struct {
 
 	TestClientSecret string
 
+	// Private salts verifying the webhooks of each environment
+	ProdSalt string
+
+	TestSalt string
+
 	ProdURL string
 
 	TestURL string
+
+	// MaxRetries is the number of times a failed Instamojo call is retried
+	MaxRetries int
+
+	RetryBaseDelay time.Duration
+
+	RetryMaxDelay time.Duration
+
+	// Deadlines for each operation, retries included
+	TokenTimeout time.Duration
+
+	OrderTimeout time.Duration
+
+	StatusTimeout time.Duration
+
+	RefundTimeout time.Duration
+
+	// Store is the order store driver, memory or bolt
+	Store string
+
+	// StorePath is the database file of file backed stores
+	StorePath string
+
+	// Timeouts of the HTTP server
+	ReadTimeout time.Duration
+
+	WriteTimeout time.Duration
+
+	IdleTimeout time.Duration
+
+	// ShutdownGrace is how long requests in flight get to finish on shutdown
+	ShutdownGrace time.Duration
+
+	// AuthKeys is the JSON file of the API keys and signing secrets of callers.
+	// Requests are not authenticated without it.
+	AuthKeys string
+
+	// SignatureSkew is how far the timestamp of a signed request may be from the server's clock
+	SignatureSkew time.Duration
+
+	// RateLimits are the request rates allowed to each caller, by operation ID
+	RateLimits string
+
+	// MaxBatchSize is the number of orders a batch status lookup may have
+	MaxBatchSize int
+
+	// BatchParallelism is the number of orders of a batch looked up at once
+	BatchParallelism int
+
+	// StreamTimeout is how long a status stream stays open, it must be shorter than WriteTimeout
+	StreamTimeout time.Duration
+
+	// StreamPollInterval is how often a streamed order is looked up between webhooks
+	StreamPollInterval time.Duration
+
+	// ReadyTimeout bounds the checks of a readiness probe
+	ReadyTimeout time.Duration
+
+	// IdempotencyTTL is how long the order created with an Idempotency-Key is returned for the same key
+	IdempotencyTTL time.Duration
+
+	// Cross-origin access allowed to browsers. No origin is allowed by default.
+	CORSOrigins []string
+
+	CORSMethods []string
+
+	CORSHeaders []string
+
+	CORSMaxAge time.Duration
+
+	// Merchants served besides the default merchant of the top level credentials
+	Merchants []Merchant
+
+	// ReloadInterval is how often the config file and the keys file are checked for changes, 0 to only reload on SIGHUP
+	ReloadInterval time.Duration
+
+	// File is the config file the settings were read from, empty when there is none
+	File string
+}
+
+// envPrefix starts the names of the environment variables of the settings
+const envPrefix = "SDK_SERVER_"
+
+// portEnv is the environment variable of the port, named like hosting platforms set it
+const portEnv = "PORT"
+
+// Default returns the settings used when no source sets them
+func Default() Config {
+	return Config{
+		Port:               "8080",
+		ProdURL:            "https://api.instamojo.com",
+		TestURL:            "https://test.instamojo.com",
+		MaxRetries:         2,
+		RetryBaseDelay:     200 * time.Millisecond,
+		RetryMaxDelay:      2 * time.Second,
+		TokenTimeout:       10 * time.Second,
+		OrderTimeout:       30 * time.Second,
+		StatusTimeout:      15 * time.Second,
+		RefundTimeout:      30 * time.Second,
+		Store:              "memory",
+		StorePath:          "orders.db",
+		ReadTimeout:        15 * time.Second,
+		WriteTimeout:       60 * time.Second,
+		IdleTimeout:        120 * time.Second,
+		ShutdownGrace:      30 * time.Second,
+		SignatureSkew:      5 * time.Minute,
+		RateLimits:         "default=600/m,createOrder=30/m,createRefund=10/m,authFailure=20/m",
+		MaxBatchSize:       100,
+		BatchParallelism:   8,
+		StreamTimeout:      50 * time.Second,
+		StreamPollInterval: 5 * time.Second,
+		ReadyTimeout:       5 * time.Second,
+		IdempotencyTTL:     24 * time.Hour,
+		CORSMethods:        []string{"GET", "POST"},
+		CORSHeaders:        []string{"Content-Type", "X-Request-ID", "X-API-Key", "X-Key-ID", "X-Timestamp", "X-Signature", "Idempotency-Key"},
+		CORSMaxAge:         10 * time.Minute,
+		ReloadInterval:     10 * time.Second,
+	}
+}
+
+// flags defines a flag for every setting, bound to the fields of c
+func (c *Config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "port", c.Port, "Port the server listens on")
+	fs.StringVar(&c.ProdClientID, "production-client-id", c.ProdClientID, "Production Client ID")
+	fs.StringVar(&c.ProdClientSecret, "production-client-secret", c.ProdClientSecret, "Production Client Secret")
+	fs.StringVar(&c.TestClientID, "test-client-id", c.TestClientID, "Test Client ID")
+	fs.StringVar(&c.TestClientSecret, "test-client-secret", c.TestClientSecret, "Test Client Secret")
+	fs.StringVar(&c.ProdSalt, "production-salt", c.ProdSalt, "Production private salt, verifies payment webhooks")
+	fs.StringVar(&c.TestSalt, "test-salt", c.TestSalt, "Test private salt, verifies payment webhooks")
+	fs.StringVar(&c.ProdURL, "production-url", c.ProdURL, "Base URL of the Instamojo production API")
+	fs.StringVar(&c.TestURL, "test-url", c.TestURL, "Base URL of the Instamojo test API")
+	fs.IntVar(&c.MaxRetries, "max-retries", c.MaxRetries, "Retries for a failed Instamojo call")
+	fs.DurationVar(&c.RetryBaseDelay, "retry-base-delay", c.RetryBaseDelay, "Backoff before the first retry, doubled for every retry after")
+	fs.DurationVar(&c.RetryMaxDelay, "retry-max-delay", c.RetryMaxDelay, "Maximum backoff between retries")
+	fs.DurationVar(&c.TokenTimeout, "token-timeout", c.TokenTimeout, "Deadline for fetching an access token")
+	fs.DurationVar(&c.OrderTimeout, "order-timeout", c.OrderTimeout, "Deadline for creating an order")
+	fs.DurationVar(&c.StatusTimeout, "status-timeout", c.StatusTimeout, "Deadline for an order status lookup")
+	fs.DurationVar(&c.RefundTimeout, "refund-timeout", c.RefundTimeout, "Deadline for initiating a refund")
+	fs.StringVar(&c.Store, "store", c.Store, "Order store: memory or bolt")
+	fs.StringVar(&c.StorePath, "store-path", c.StorePath, "Database file of the bolt order store")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "Deadline for reading a request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "Deadline for writing a response, should exceed the operation timeouts")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "How long an idle keep-alive connection is kept open")
+	fs.DurationVar(&c.ShutdownGrace, "shutdown-grace", c.ShutdownGrace, "How long requests in flight get to finish on shutdown")
+	fs.StringVar(&c.AuthKeys, "auth-keys", c.AuthKeys, "JSON file of the API keys and signing secrets of callers")
+	fs.DurationVar(&c.SignatureSkew, "signature-skew", c.SignatureSkew, "How far the timestamp of a signed request may be from the server's clock")
+	fs.StringVar(&c.RateLimits, "rate-limits", c.RateLimits, "Requests allowed to each caller by operation ID, as name=count/unit[:burst] separated by commas")
+	fs.IntVar(&c.MaxBatchSize, "max-batch-size", c.MaxBatchSize, "Orders a batch status lookup may have")
+	fs.IntVar(&c.BatchParallelism, "batch-parallelism", c.BatchParallelism, "Orders of a batch status lookup looked up at once")
+	fs.DurationVar(&c.StreamTimeout, "stream-timeout", c.StreamTimeout, "How long a status stream stays open, must be shorter than the write timeout")
+	fs.DurationVar(&c.StreamPollInterval, "stream-poll-interval", c.StreamPollInterval, "How often a streamed order is looked up between webhooks")
+	fs.DurationVar(&c.ReadyTimeout, "ready-timeout", c.ReadyTimeout, "Deadline for the checks of a readiness probe")
+	fs.DurationVar(&c.IdempotencyTTL, "idempotency-ttl", c.IdempotencyTTL, "How long the order created with an Idempotency-Key is returned for the same key")
+	fs.Var((*list)(&c.CORSOrigins), "cors-origins", "Origins allowed to call the server from a browser, separated by commas, * for any")
+	fs.Var((*list)(&c.CORSMethods), "cors-methods", "Methods allowed to cross-origin requests, separated by commas")
+	fs.Var((*list)(&c.CORSHeaders), "cors-headers", "Headers allowed to cross-origin requests, separated by commas")
+	fs.DurationVar(&c.CORSMaxAge, "cors-max-age", c.CORSMaxAge, "How long browsers may cache a preflight reply")
+	fs.Var((*merchants)(&c.Merchants), "merchants", "JSON array of the merchants served besides the default merchant")
+	fs.DurationVar(&c.ReloadInterval, "reload-interval", c.ReloadInterval, "How often the config file and the keys file are checked for changes, 0 to only reload on SIGHUP")
 }
 
-// Config stores the configs
-var Config config
+// Load reads the settings from, in increasing order of precedence: the defaults, the JSON config file
+// named by the -config flag or the SDK_SERVER_CONFIG variable, the environment and the command line args.
+// Each setting is named like its flag, in the config file, and in upper case with an SDK_SERVER_ prefix
+// and underscores in the environment, like SDK_SERVER_PRODUCTION_CLIENT_ID. The port is PORT.
+func Load(args []string) (*Config, error) {
+	c := Default()
+	fs := flag.NewFlagSet("sample-sdk-server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "JSON config file")
+	c.flags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// The flags were applied first to find the config file. The file and the environment
+	// are applied over them, so the flags set on the command line are applied again last.
+	fromArgs := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		fromArgs[f.Name] = f.Value.String()
+	})
+
+	if *configFile != "" {
+		if err := loadFile(fs, *configFile); err != nil {
+			return nil, err
+		}
+	}
+	c.File = *configFile
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if ok && err == nil && f.Name != "config" {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("invalid %s: %v", envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range fromArgs {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// envName returns the environment variable of a setting
+func envName(name string) string {
+	if name == "port" {
+		return portEnv
+	}
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadFile applies the settings of a JSON config file, an object with a member per setting.
+// Durations are strings like "30s", lists are arrays of strings and merchants an array of objects.
+func loadFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("config file %s: unknown setting %s", path, name)
+		}
 
-func init() {
-	prodClientID := flag.String("production-client-id", "", "Production Client ID")
-	prodClientSecret := flag.String("production-client-secret", "", "Production Client Secret")
-	testClientID := flag.String("test-client-id", "", "Test Client ID")
-	testClientSecret := flag.String("test-client-secret", "", "Test Client Secret")
-	flag.Parse()
+		var value string
+		var err error
+		if _, ok := fs.Lookup(name).Value.(jsonFlag); ok {
+			var data []byte
+			data, err = json.Marshal(settings[name])
+			value = string(data)
+		} else {
+			value, err = settingString(settings[name])
+		}
+		if err != nil {
+			return fmt.Errorf("config file %s: %s %v", path, name, err)
+		}
 
-	if *prodClientID == "" {
-		log.Fatal("Production Client ID is missing")
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config file %s: invalid %s: %v", path, name, err)
+		}
 	}
 
-	if *prodClientSecret == "" {
-		log.Fatal("Production Client secret is missing")
+	return nil
+}
+
+// settingString returns a JSON value as it would be written on the command line
+func settingString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", errors.New("must be a list of strings")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.New("must be a string, a number, a boolean or a list of strings")
+	}
+}
+
+// Validate checks the settings are complete and consistent
+func (c *Config) Validate() error {
+	if err := c.validateMerchants(); err != nil {
+		return err
 	}
 
-	if *testClientID == "" {
-		log.Fatal("Test Client ID is missing")
+	if c.WriteTimeout > 0 && c.StreamTimeout >= c.WriteTimeout {
+		return errors.New("Stream timeout must be shorter than the write timeout")
 	}
 
-	if *testClientSecret == "" {
-		log.Fatal("Test Client Secret is missing")
+	switch {
+	case c.TokenTimeout <= 0:
+		return errors.New("Token timeout must be positive")
+	case c.StreamPollInterval <= 0:
+		return errors.New("Stream poll interval must be positive")
+	case c.ReadyTimeout <= 0:
+		return errors.New("Ready timeout must be positive")
+	case c.MaxBatchSize <= 0:
+		return errors.New("Max batch size must be positive")
+	case c.BatchParallelism <= 0:
+		return errors.New("Batch parallelism must be positive")
 	}
 
-	Config = config{
-		ProdClientID:     *prodClientID,
-		ProdClientSecret: *prodClientSecret,
-		TestClientID:     *testClientID,
-		TestClientSecret: *testClientSecret,
-		ProdURL:          "https://api.instamojo.com",
-		TestURL:          "https://test.instamojo.com",
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("Port %q is not a port number", c.Port)
+	}
+
+	return nil
+}
+
+// jsonFlag is a flag whose value is JSON
+type jsonFlag interface {
+	isJSON()
+}
+
+// list is a flag of comma separated items
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
 	}
+	return nil
 }
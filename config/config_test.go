@@ -0,0 +1,142 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// credentials are the command line args of the settings Load requires
+var credentials = []string{
+	"-production-client-id", "prod-id", "-production-client-secret", "prod-secret",
+	"-test-client-id", "test-id", "-test-client-secret", "test-secret",
+}
+
+// setEnv sets the environment variables of env, an empty value unsetting one,
+// and returns the function restoring them
+func setEnv(env map[string]string) func() {
+	previous := map[string]*string{}
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+// writeConfigFile writes a config file and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "config*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfigFile(t, `{"max-retries": 3, "port": "8081", "cors-origins": ["https://file.example"]}`)
+	defer os.Remove(file)
+
+	tests := []struct {
+		name string
+
+		args []string
+
+		env map[string]string
+
+		maxRetries int
+
+		port string
+
+		origins string
+	}{
+		{"defaults", nil, nil, 2, "8080", ""},
+		{"file", []string{"-config", file}, nil, 3, "8081", "https://file.example"},
+		{"file from the environment", nil, map[string]string{"SDK_SERVER_CONFIG": file}, 3, "8081", "https://file.example"},
+		{"environment over file", []string{"-config", file}, map[string]string{"SDK_SERVER_MAX_RETRIES": "4", "PORT": "8082"}, 4, "8082", "https://file.example"},
+		{"args over environment", []string{"-config", file, "-max-retries", "5", "-cors-origins", "https://args.example"},
+			map[string]string{"SDK_SERVER_MAX_RETRIES": "4", "PORT": "8082"}, 5, "8082", "https://args.example"},
+		{"args without file", []string{"-max-retries", "0", "-port", "8083"}, nil, 0, "8083", ""},
+	}
+
+	for _, test := range tests {
+		env := map[string]string{"SDK_SERVER_CONFIG": "", "SDK_SERVER_MAX_RETRIES": "", "PORT": "", "SDK_SERVER_CORS_ORIGINS": ""}
+		for name, value := range test.env {
+			env[name] = value
+		}
+		restore := setEnv(env)
+
+		cfg, err := Load(append(test.args, credentials...))
+		restore()
+		if err != nil {
+			t.Errorf("%s: Load() error %v", test.name, err)
+			continue
+		}
+
+		origins := strings.Join(cfg.CORSOrigins, ",")
+		if cfg.MaxRetries != test.maxRetries || cfg.Port != test.port || origins != test.origins {
+			t.Errorf("%s: Load() = max-retries %d, port %s, cors-origins %q, want %d, %s, %q",
+				test.name, cfg.MaxRetries, cfg.Port, origins, test.maxRetries, test.port, test.origins)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	unknown := writeConfigFile(t, `{"max-retry": 3}`)
+	defer os.Remove(unknown)
+
+	tests := []struct {
+		name string
+
+		args []string
+
+		env map[string]string
+
+		err string
+	}{
+		{"unknown setting in file", []string{"-config", unknown}, nil, "unknown setting max-retry"},
+		{"missing file", []string{"-config", unknown + ".missing"}, nil, "no such file"},
+		{"invalid environment", nil, map[string]string{"SDK_SERVER_MAX_RETRIES": "many"}, "invalid SDK_SERVER_MAX_RETRIES"},
+		{"invalid port", []string{"-port", "http"}, nil, "is not a port number"},
+		{"non-positive batch size", []string{"-max-batch-size", "0"}, nil, "Max batch size must be positive"},
+		{"non-positive token timeout", []string{"-token-timeout", "0s"}, nil, "Token timeout must be positive"},
+		{"non-positive poll interval", []string{"-stream-poll-interval", "0s"}, nil, "Stream poll interval must be positive"},
+	}
+
+	for _, test := range tests {
+		env := map[string]string{"SDK_SERVER_CONFIG": "", "SDK_SERVER_MAX_RETRIES": "", "PORT": ""}
+		for name, value := range test.env {
+			env[name] = value
+		}
+		restore := setEnv(env)
+
+		_, err := Load(append(test.args, credentials...))
+		restore()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Load() error %v, want one containing %q", test.name, err, test.err)
+		}
+	}
+}
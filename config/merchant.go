@@ -0,0 +1,140 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DefaultMerchant is the ID of the merchant of the top level credentials
+const DefaultMerchant = "default"
+
+// Merchant is a merchant served by the server, with its own Instamojo credentials
+type Merchant struct {
+	ID string `json:"id"`
+
+	// Credentials of each environment, nil when the merchant doesn't use it
+	Production *Credentials `json:"production,omitempty"`
+
+	Test *Credentials `json:"test,omitempty"`
+
+	// RedirectURL is where buyers land after paying, the Instamojo SDK redirect when empty
+	RedirectURL string `json:"redirect_url,omitempty"`
+
+	// Currency of the orders, INR when empty
+	Currency string `json:"currency,omitempty"`
+}
+
+// Credentials of a merchant for one Instamojo environment
+type Credentials struct {
+	ClientID string `json:"client_id"`
+
+	ClientSecret string `json:"client_secret"`
+
+	// Salt verifies the payment webhooks
+	Salt string `json:"salt,omitempty"`
+
+	// URL of the Instamojo API, the one of the environment when empty
+	URL string `json:"url,omitempty"`
+}
+
+// Registry returns every merchant: the default merchant when the top level credentials
+// are set, then the merchants of the Merchants setting, with the URLs of their environments filled in
+func (c *Config) Registry() []Merchant {
+	var merchants []Merchant
+	if c.hasDefaultMerchant() {
+		merchants = append(merchants, Merchant{
+			ID:         DefaultMerchant,
+			Production: &Credentials{ClientID: c.ProdClientID, ClientSecret: c.ProdClientSecret, Salt: c.ProdSalt},
+			Test:       &Credentials{ClientID: c.TestClientID, ClientSecret: c.TestClientSecret, Salt: c.TestSalt},
+		})
+	}
+	merchants = append(merchants, c.Merchants...)
+
+	for i := range merchants {
+		merchant := merchants[i]
+		if merchant.Production != nil && merchant.Production.URL == "" {
+			production := *merchant.Production
+			production.URL = c.ProdURL
+			merchant.Production = &production
+		}
+
+		if merchant.Test != nil && merchant.Test.URL == "" {
+			test := *merchant.Test
+			test.URL = c.TestURL
+			merchant.Test = &test
+		}
+		merchants[i] = merchant
+	}
+
+	return merchants
+}
+
+func (c *Config) hasDefaultMerchant() bool {
+	return c.ProdClientID != "" || c.ProdClientSecret != "" || c.TestClientID != "" || c.TestClientSecret != ""
+}
+
+// validateMerchants checks the default merchant has the credentials of both environments,
+// and the other merchants have unique IDs and the credentials of at least one environment
+func (c *Config) validateMerchants() error {
+	if c.hasDefaultMerchant() || len(c.Merchants) == 0 {
+		switch {
+		case c.ProdClientID == "":
+			return fmt.Errorf("Production Client ID is missing")
+		case c.ProdClientSecret == "":
+			return fmt.Errorf("Production Client secret is missing")
+		case c.TestClientID == "":
+			return fmt.Errorf("Test Client ID is missing")
+		case c.TestClientSecret == "":
+			return fmt.Errorf("Test Client Secret is missing")
+		}
+	}
+
+	ids := map[string]bool{DefaultMerchant: c.hasDefaultMerchant()}
+	for i, merchant := range c.Merchants {
+		if merchant.ID == "" {
+			return fmt.Errorf("merchant %d has no id", i)
+		}
+
+		if ids[merchant.ID] {
+			return fmt.Errorf("merchant %s is listed twice", merchant.ID)
+		}
+		ids[merchant.ID] = true
+
+		if merchant.Production == nil && merchant.Test == nil {
+			return fmt.Errorf("merchant %s has no credentials", merchant.ID)
+		}
+
+		for _, credentials := range []*Credentials{merchant.Production, merchant.Test} {
+			if credentials != nil && (credentials.ClientID == "" || credentials.ClientSecret == "") {
+				return fmt.Errorf("merchant %s is missing a client ID or secret", merchant.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// merchants is the flag of the Merchants setting, a JSON array of merchants
+type merchants []Merchant
+
+func (m *merchants) String() string {
+	if len(*m) == 0 {
+		return ""
+	}
+
+	data, _ := json.Marshal(*m)
+	return string(data)
+}
+
+func (m *merchants) Set(value string) error {
+	var parsed []Merchant
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// isJSON marks the flag as taking JSON, so the config file can have it as is
+func (m *merchants) isJSON() {}
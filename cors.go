@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exposedHeaders are the response headers browsers let scripts read
+var exposedHeaders = []string{requestIDHeader, "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "Deprecation", "Link", idempotentReplayedHeader}
+
+// CORSPolicy is the cross-origin access allowed to browsers
+type CORSPolicy struct {
+	// Origins allowed, "*" allows any
+	Origins []string
+
+	Methods []string
+
+	// Headers browsers may send
+	Headers []string
+
+	// MaxAge is how long browsers may cache a preflight reply
+	MaxAge time.Duration
+}
+
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CORSPolicy) allowsMethod(method string) bool {
+	for _, allowed := range p.Methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether every header of a comma separated list is allowed
+func (p CORSPolicy) allowsHeaders(headers string) bool {
+	for _, header := range strings.Split(headers, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+
+		allowed := false
+		for _, allowedHeader := range p.Headers {
+			if strings.EqualFold(allowedHeader, header) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// CORSHandler applies policy to the requests of browsers. Preflight requests are answered
+// before they reach handler, since its routes only accept their own methods.
+func CORSHandler(policy CORSPolicy, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := policy.allowsOrigin(origin)
+
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if !allowed || !policy.allowsMethod(requestedMethod) || !policy.allowsHeaders(requestedHeaders) {
+				writeErrorCode(w, http.StatusForbidden, codeForbidden, "Cross-origin request not allowed")
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin(policy, origin))
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin(policy, origin))
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin is the Access-Control-Allow-Origin sent to origin
+func allowedOrigin(policy CORSPolicy, origin string) string {
+	for _, allowed := range policy.Origins {
+		if allowed == "*" {
+			return "*"
+		}
+	}
+	return origin
+}
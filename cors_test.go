@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSHandler(t *testing.T) {
+	exact := CORSPolicy{
+		Origins: []string{"https://shop.example.com"},
+		Methods: []string{"GET", "POST"},
+		Headers: []string{"Content-Type", "X-API-Key"},
+		MaxAge:  10 * time.Minute,
+	}
+	anyOrigin := exact
+	anyOrigin.Origins = []string{"*"}
+
+	const preflightVary = "Origin, Access-Control-Request-Method, Access-Control-Request-Headers"
+
+	tests := []struct {
+		name string
+
+		policy CORSPolicy
+
+		method, origin string
+
+		// requestMethod and requestHeaders are asked for by a preflight request
+		requestMethod, requestHeaders string
+
+		status int
+
+		// allowOrigin is the Access-Control-Allow-Origin expected, and vary the Vary headers joined
+		allowOrigin, vary string
+
+		// served is whether the request reaches the routes
+		served bool
+	}{
+		{"same origin", exact, "GET", "", "", "", http.StatusOK, "", "", true},
+		{"allowed origin", exact, "GET", "https://shop.example.com", "", "", http.StatusOK, "https://shop.example.com", "Origin", true},
+		{"other origin", exact, "GET", "https://evil.example.com", "", "", http.StatusOK, "", "Origin", true},
+		{"any origin", anyOrigin, "POST", "https://evil.example.com", "", "", http.StatusOK, "*", "Origin", true},
+		{"preflight allowed", exact, "OPTIONS", "https://shop.example.com", "POST", "content-type, X-API-Key", http.StatusNoContent, "https://shop.example.com", preflightVary, false},
+		{"preflight of any origin", anyOrigin, "OPTIONS", "https://evil.example.com", "GET", "", http.StatusNoContent, "*", preflightVary, false},
+		{"preflight of other origin", exact, "OPTIONS", "https://evil.example.com", "GET", "", http.StatusForbidden, "", preflightVary, false},
+		{"preflight of other method", exact, "OPTIONS", "https://shop.example.com", "DELETE", "", http.StatusForbidden, "", preflightVary, false},
+		{"preflight of other header", anyOrigin, "OPTIONS", "https://shop.example.com", "POST", "Content-Type, X-Debug", http.StatusForbidden, "", preflightVary, false},
+		{"options without a requested method", exact, "OPTIONS", "https://shop.example.com", "", "", http.StatusOK, "https://shop.example.com", "Origin", true},
+	}
+
+	for _, test := range tests {
+		served := false
+		handler := CORSHandler(test.policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = true
+		}))
+
+		r := httptest.NewRequest(test.method, "/v1/order", nil)
+		for name, value := range map[string]string{"Origin": test.origin, "Access-Control-Request-Method": test.requestMethod, "Access-Control-Request-Headers": test.requestHeaders} {
+			if value != "" {
+				r.Header.Set(name, value)
+			}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status || served != test.served {
+			t.Errorf("%s: status %d, served %v, want %d, %v", test.name, w.Code, served, test.status, test.served)
+		}
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want %q", test.name, got, test.allowOrigin)
+		}
+
+		if got := strings.Join(w.Header()["Vary"], ", "); got != test.vary {
+			t.Errorf("%s: Vary %q, want %q", test.name, got, test.vary)
+		}
+
+		preflight := w.Code == http.StatusNoContent
+		if got := w.Header().Get("Access-Control-Allow-Methods"); (got == "GET, POST") != preflight {
+			t.Errorf("%s: Access-Control-Allow-Methods %q", test.name, got)
+		}
+		if got := w.Header().Get("Access-Control-Max-Age"); (got == "600") != preflight {
+			t.Errorf("%s: Access-Control-Max-Age %q", test.name, got)
+		}
+
+		exposed := served && test.allowOrigin != ""
+		if got := w.Header().Get("Access-Control-Expose-Headers"); (got != "") != exposed {
+			t.Errorf("%s: Access-Control-Expose-Headers %q, want them exposed %v", test.name, got, exposed)
+		}
+	}
+}
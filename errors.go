@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/instamojo/sample-sdk-server/auth"
+	"github.com/instamojo/sample-sdk-server/idempotency"
+	"github.com/instamojo/sample-sdk-server/lib"
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+// Error codes sent in model.ErrorResponse. They are documented in the Readme.
+const (
+	codeInvalidRequest       = "invalid_request"
+	codeValidationFailed     = "validation_failed"
+	codeInvalidAmount        = "invalid_amount"
+	codeInvalidRefundType    = "invalid_refund_type"
+	codeNoPayment            = "no_payment"
+	codePaymentNotSuccessful = "payment_not_successful"
+	codeRefundExceedsAmount  = "refund_exceeds_amount"
+	codeNotFound             = "not_found"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeEnvNotConfigured     = "env_not_configured"
+	codeInvalidWebhookMAC    = "invalid_webhook_mac"
+	codeRateLimited          = "rate_limited"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codeUpstreamRejected     = "upstream_rejected"
+	codeUpstreamAuthFailed   = "upstream_auth_failed"
+	codeUpstreamUnavailable  = "upstream_unavailable"
+	codeUpstreamError        = "upstream_error"
+	codeUpstreamTimeout      = "upstream_timeout"
+	codeRequestCanceled      = "request_canceled"
+	codeInternalError        = "internal_error"
+)
+
+// statusClientClosedRequest is the status of a request the caller gave up on, as nginx logs it.
+// The caller is gone, so it only shows in the logs.
+const statusClientClosedRequest = 499
+
+// requestIDHeader carries the ID of a request, in the request as well as the response
+const requestIDHeader = "X-Request-ID"
+
+// errorResponse is the reply for an error: its status and body
+type errorResponse struct {
+	status int
+	body   model.ErrorResponse
+}
+
+// errorFor maps an error returned by lib to the reply sent to the caller
+func errorFor(err error) errorResponse {
+	switch err {
+	case lib.ErrInvalidAmount:
+		return newErrorResponse(http.StatusBadRequest, codeInvalidAmount, err.Error())
+	case lib.ErrInvalidRefundType:
+		return newErrorResponse(http.StatusBadRequest, codeInvalidRefundType, err.Error())
+	case lib.ErrNoPayment:
+		return newErrorResponse(http.StatusBadRequest, codeNoPayment, err.Error())
+	case lib.ErrPaymentNotSuccessful:
+		return newErrorResponse(http.StatusBadRequest, codePaymentNotSuccessful, err.Error())
+	case lib.ErrRefundExceedsAmount:
+		return newErrorResponse(http.StatusBadRequest, codeRefundExceedsAmount, err.Error())
+	case auth.ErrNoCredentials, auth.ErrInvalidCredentials, auth.ErrInvalidSignature, auth.ErrStaleRequest, auth.ErrReplayedRequest:
+		return newErrorResponse(http.StatusUnauthorized, codeUnauthorized, err.Error())
+	case auth.ErrBodyTooLarge:
+		return newErrorResponse(http.StatusRequestEntityTooLarge, codeInvalidRequest, err.Error())
+	case errEnvNotConfigured:
+		return newErrorResponse(http.StatusBadRequest, codeEnvNotConfigured, err.Error())
+	case idempotency.ErrKeyReused:
+		return newErrorResponse(http.StatusConflict, codeIdempotencyKeyReused, err.Error())
+	case store.ErrNotFound:
+		return newErrorResponse(http.StatusNotFound, codeNotFound, "Not found")
+	}
+
+	if validationErr, ok := err.(*lib.ValidationError); ok {
+		response := newErrorResponse(http.StatusBadRequest, codeValidationFailed, "Some fields are invalid")
+		response.body.Fields = validationErr.Fields
+		return response
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newErrorResponse(http.StatusGatewayTimeout, codeUpstreamTimeout, "Instamojo did not respond in time")
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return newErrorResponse(statusClientClosedRequest, codeRequestCanceled, "The request was canceled")
+	}
+
+	// Refused connections, DNS failures and resets
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return newErrorResponse(http.StatusServiceUnavailable, codeUpstreamUnavailable, "Instamojo could not be reached")
+	}
+
+	var invalidErr *lib.InvalidResponseError
+	if errors.As(err, &invalidErr) {
+		return newErrorResponse(http.StatusBadGateway, codeUpstreamError, "Instamojo sent an invalid response")
+	}
+
+	apiErr, ok := err.(*lib.APIError)
+	if !ok {
+		return newErrorResponse(http.StatusInternalServerError, codeInternalError, "Internal error")
+	}
+
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+		// Our credentials were rejected, nothing the caller can fix
+		return newErrorResponse(http.StatusBadGateway, codeUpstreamAuthFailed, "Instamojo rejected the server's credentials")
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		return newErrorResponse(http.StatusServiceUnavailable, codeUpstreamUnavailable, "Instamojo is rate limiting requests")
+	case apiErr.StatusCode == http.StatusNotFound:
+		return newErrorResponse(http.StatusNotFound, codeNotFound, apiErr.Message)
+	case apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+		response := newErrorResponse(http.StatusBadRequest, codeUpstreamRejected, apiErr.Message)
+		response.body.Fields = apiErr.Fields
+		return response
+	case apiErr.StatusCode == http.StatusServiceUnavailable:
+		return newErrorResponse(http.StatusServiceUnavailable, codeUpstreamUnavailable, "Instamojo is unavailable")
+	default:
+		return newErrorResponse(http.StatusBadGateway, codeUpstreamError, "Instamojo failed to process the request")
+	}
+}
+
+func newErrorResponse(status int, code, message string) errorResponse {
+	return errorResponse{
+		status: status,
+		body:   model.ErrorResponse{Code: code, Message: message},
+	}
+}
+
+// writeError replies with the error response matching err
+func writeError(w http.ResponseWriter, err error) {
+	response := errorFor(err)
+	response.write(w)
+}
+
+// writeErrorCode replies with an error response for a failure found by the handler itself
+func writeErrorCode(w http.ResponseWriter, status int, code, message string) {
+	response := newErrorResponse(status, code, message)
+	response.write(w)
+}
+
+func (e errorResponse) write(w http.ResponseWriter) {
+	e.body.RequestID = w.Header().Get(requestIDHeader)
+	writeJSON(w, e.status, e.body)
+}
+
+// writeJSON replies with v as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Encoding error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// notFoundHandler replies to requests no route matches
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeErrorCode(w, http.StatusNotFound, codeNotFound, "No such endpoint")
+}
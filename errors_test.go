@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/instamojo/sample-sdk-server/auth"
+	"github.com/instamojo/sample-sdk-server/idempotency"
+	"github.com/instamojo/sample-sdk-server/lib"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+func TestErrorFor(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name string
+
+		err error
+
+		status int
+
+		code string
+	}{
+		{"invalid amount", lib.ErrInvalidAmount, http.StatusBadRequest, codeInvalidAmount},
+		{"invalid refund type", lib.ErrInvalidRefundType, http.StatusBadRequest, codeInvalidRefundType},
+		{"no payment", lib.ErrNoPayment, http.StatusBadRequest, codeNoPayment},
+		{"payment not successful", lib.ErrPaymentNotSuccessful, http.StatusBadRequest, codePaymentNotSuccessful},
+		{"refund exceeds amount", lib.ErrRefundExceedsAmount, http.StatusBadRequest, codeRefundExceedsAmount},
+		{"validation", &lib.ValidationError{Fields: map[string][]string{"amount": {"is required"}}}, http.StatusBadRequest, codeValidationFailed},
+		{"environment not configured", errEnvNotConfigured, http.StatusBadRequest, codeEnvNotConfigured},
+		{"no credentials", auth.ErrNoCredentials, http.StatusUnauthorized, codeUnauthorized},
+		{"invalid credentials", auth.ErrInvalidCredentials, http.StatusUnauthorized, codeUnauthorized},
+		{"invalid signature", auth.ErrInvalidSignature, http.StatusUnauthorized, codeUnauthorized},
+		{"stale request", auth.ErrStaleRequest, http.StatusUnauthorized, codeUnauthorized},
+		{"replayed request", auth.ErrReplayedRequest, http.StatusUnauthorized, codeUnauthorized},
+		{"body too large", auth.ErrBodyTooLarge, http.StatusRequestEntityTooLarge, codeInvalidRequest},
+		{"not in the store", store.ErrNotFound, http.StatusNotFound, codeNotFound},
+		{"idempotency key reused", idempotency.ErrKeyReused, http.StatusConflict, codeIdempotencyKeyReused},
+		{"unknown", errors.New("disk full"), http.StatusInternalServerError, codeInternalError},
+		{"deadline", fmt.Errorf("fetching the token: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, codeUpstreamTimeout},
+		{"canceled", fmt.Errorf("fetching the token: %w", context.Canceled), statusClientClosedRequest, codeRequestCanceled},
+		{"unreachable", fmt.Errorf("fetching the token: %w", refused), http.StatusServiceUnavailable, codeUpstreamUnavailable},
+		{"invalid response", &lib.InvalidResponseError{Err: errors.New("unexpected EOF")}, http.StatusBadGateway, codeUpstreamError},
+		{"credentials rejected", &lib.APIError{StatusCode: http.StatusUnauthorized}, http.StatusBadGateway, codeUpstreamAuthFailed},
+		{"credentials forbidden", &lib.APIError{StatusCode: http.StatusForbidden}, http.StatusBadGateway, codeUpstreamAuthFailed},
+		{"rate limited upstream", &lib.APIError{StatusCode: http.StatusTooManyRequests}, http.StatusServiceUnavailable, codeUpstreamUnavailable},
+		{"not found upstream", &lib.APIError{StatusCode: http.StatusNotFound, Message: "Not found"}, http.StatusNotFound, codeNotFound},
+		{"rejected upstream", &lib.APIError{StatusCode: http.StatusBadRequest, Fields: map[string][]string{"phone": {"is invalid"}}}, http.StatusBadRequest, codeUpstreamRejected},
+		{"unavailable upstream", &lib.APIError{StatusCode: http.StatusServiceUnavailable}, http.StatusServiceUnavailable, codeUpstreamUnavailable},
+		{"failed upstream", &lib.APIError{StatusCode: http.StatusInternalServerError}, http.StatusBadGateway, codeUpstreamError},
+	}
+
+	for _, test := range tests {
+		got := errorFor(test.err)
+		if got.status != test.status || got.body.Code != test.code {
+			t.Errorf("%s: errorFor(%v) = %d %s, want %d %s", test.name, test.err, got.status, got.body.Code, test.status, test.code)
+		}
+	}
+
+	// The fields that failed are passed on
+	fields := map[string][]string{"phone": {"is invalid"}}
+	for _, err := range []error{&lib.ValidationError{Fields: fields}, &lib.APIError{StatusCode: http.StatusBadRequest, Fields: fields}} {
+		if got := errorFor(err).body.Fields; len(got["phone"]) != 1 || got["phone"][0] != "is invalid" {
+			t.Errorf("errorFor(%v) fields %v, want %v", err, got, fields)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/lib"
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// Statuses of the health checks
+const (
+	healthOK     = "ok"
+	healthFailed = "failed"
+)
+
+// lastErrors keeps the latest error of each readiness check, by check name
+var lastErrors = struct {
+	sync.Mutex
+
+	errors map[string]lastError
+}{errors: map[string]lastError{}}
+
+type lastError struct {
+	message string
+
+	at time.Time
+}
+
+// readinessCheck checks a dependency the server can't serve requests without
+type readinessCheck struct {
+	name string
+
+	check func(ctx context.Context) error
+}
+
+// readinessChecks lists the store and the token of every environment of every merchant
+func readinessChecks(clients map[string]map[string]*lib.Client) []readinessCheck {
+	checks := []readinessCheck{{
+		name:  "store",
+		check: func(ctx context.Context) error { return orderStore.Ping() },
+	}}
+
+	merchantIDs := make([]string, 0, len(clients))
+	for merchantID := range clients {
+		merchantIDs = append(merchantIDs, merchantID)
+	}
+	sort.Strings(merchantIDs)
+
+	for _, merchantID := range merchantIDs {
+		envs := make([]string, 0, len(clients[merchantID]))
+		for env := range clients[merchantID] {
+			envs = append(envs, env)
+		}
+		sort.Strings(envs)
+
+		for _, env := range envs {
+			client := clients[merchantID][env]
+			checks = append(checks, readinessCheck{name: "token:" + merchantID + "/" + env, check: client.CheckToken})
+		}
+	}
+
+	return checks
+}
+
+// healthzHandler replies to liveness probes. It succeeds as long as the server can answer.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, model.HealthReport{Status: healthOK})
+}
+
+// readyzHandler replies to readiness probes. It checks every dependency at once, and fails unless all succeed.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	s := settingsOf(r)
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.ReadyTimeout)
+	defer cancel()
+
+	checks := readinessChecks(s.clients)
+	report := model.HealthReport{Status: healthOK, Checks: make([]model.HealthCheck, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check readinessCheck) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status != healthOK {
+			report.Status = healthFailed
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, report)
+}
+
+// runCheck runs check, recording its error as the last one if it fails
+func runCheck(ctx context.Context, check readinessCheck) model.HealthCheck {
+	start := time.Now()
+	err := check.check(ctx)
+	result := model.HealthCheck{
+		Name:      check.name,
+		Status:    healthOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	lastErrors.Lock()
+	defer lastErrors.Unlock()
+
+	if err != nil {
+		result.Status = healthFailed
+		result.Error = err.Error()
+		lastErrors.errors[check.name] = lastError{message: err.Error(), at: start}
+	}
+
+	if last, ok := lastErrors.errors[check.name]; ok {
+		result.LastError = last.message
+		result.LastErrorAt = &last.at
+	}
+
+	return result
+}
@@ -0,0 +1,119 @@
+// Package idempotency makes retried requests return the result of the original request
+package idempotency
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrKeyReused is returned when a key is sent again with a different request body
+var ErrKeyReused = errors.New("Idempotency key was already used with a different request")
+
+// Cache remembers the result of each request by its idempotency key, for a time to live
+type Cache struct {
+	ttl time.Duration
+
+	now func() time.Time
+
+	mu sync.Mutex
+
+	entries map[string]*entry
+
+	lastSweep time.Time
+}
+
+type entry struct {
+	body [sha256.Size]byte
+
+	// done is closed once the result is known
+	done chan struct{}
+
+	result interface{}
+
+	err error
+
+	// checkpoint is the progress fn recorded, handed to the next attempt after a failure
+	checkpoint string
+
+	expires time.Time
+}
+
+// NewCache returns a Cache remembering results for ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+// Do returns the result of fn, running it only for the first request with key.
+// Later requests with key and the same body get the result of the first one, waiting
+// for it if needed, and replayed is true. A different body gets ErrKeyReused.
+//
+// Failures aren't remembered, so a request can be retried with the same key after one.
+// fn may record its progress in checkpoint, e.g. the ID of what it created before failing.
+// The retry runs fn again with that checkpoint, so it can finish the work instead of redoing it.
+func (c *Cache) Do(key string, body []byte, fn func(checkpoint *string) (interface{}, error)) (result interface{}, replayed bool, err error) {
+	hash := sha256.Sum256(body)
+
+	c.mu.Lock()
+	now := c.now()
+	c.sweep(now)
+
+	var checkpoint string
+	if existing, ok := c.entries[key]; ok && (existing.expires.IsZero() || !now.After(existing.expires)) {
+		if existing.body != hash {
+			c.mu.Unlock()
+			return nil, false, ErrKeyReused
+		}
+
+		select {
+		case <-existing.done:
+			if existing.err == nil {
+				c.mu.Unlock()
+				return existing.result, true, nil
+			}
+			// A failed attempt, which this one resumes
+			checkpoint = existing.checkpoint
+		default:
+			c.mu.Unlock()
+			<-existing.done
+			return existing.result, existing.err == nil, existing.err
+		}
+	}
+
+	e := &entry{body: hash, done: make(chan struct{}), checkpoint: checkpoint}
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	e.result, e.err = fn(&checkpoint)
+
+	c.mu.Lock()
+	e.checkpoint = checkpoint
+	if e.err != nil && checkpoint == "" {
+		delete(c.entries, key)
+	} else {
+		e.expires = c.now().Add(c.ttl)
+	}
+	c.mu.Unlock()
+
+	close(e.done)
+	return e.result, false, e.err
+}
+
+// sweep forgets the expired results. c.mu must be held.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+
+	for key, e := range c.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
@@ -0,0 +1,148 @@
+package idempotency
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+func TestDo(t *testing.T) {
+	type request struct {
+		key, body string
+
+		// after is how long after the first request it is sent
+		after time.Duration
+
+		// result and err are returned by fn, which records checkpoint when it isn't empty
+		result interface{}
+
+		err error
+
+		checkpoint string
+	}
+
+	tests := []struct {
+		name string
+
+		requests []request
+
+		// what the last request gets
+		result interface{}
+
+		replayed bool
+
+		err error
+
+		// runs is how many times fn ran, and resumed the checkpoint it was given last
+		runs int
+
+		resumed string
+	}{
+		{"first", []request{{key: "k", body: "b", result: 1}}, 1, false, nil, 1, ""},
+		{"same body", []request{{key: "k", body: "b", result: 1}, {key: "k", body: "b", result: 2}}, 1, true, nil, 1, ""},
+		{"different body", []request{{key: "k", body: "b", result: 1}, {key: "k", body: "c", result: 2}}, nil, false, ErrKeyReused, 1, ""},
+		{"other key", []request{{key: "k", body: "b", result: 1}, {key: "l", body: "c", result: 2}}, 2, false, nil, 2, ""},
+		{"expired", []request{{key: "k", body: "b", result: 1}, {key: "k", body: "c", after: 2 * time.Hour, result: 2}}, 2, false, nil, 2, ""},
+		{"failed", []request{{key: "k", body: "b", err: errFailed}}, nil, false, errFailed, 1, ""},
+		{"retried after a failure", []request{{key: "k", body: "b", err: errFailed}, {key: "k", body: "b", result: 2}}, 2, false, nil, 2, ""},
+		{"other body after a failure", []request{{key: "k", body: "b", err: errFailed}, {key: "k", body: "c", result: 2}}, 2, false, nil, 2, ""},
+		{"resumed after a failure", []request{
+			{key: "k", body: "b", err: errFailed, checkpoint: "tx-1"},
+			{key: "k", body: "b", result: 2},
+			{key: "k", body: "b", result: 3},
+		}, 2, true, nil, 2, "tx-1"},
+		{"different body after a failure with checkpoint", []request{
+			{key: "k", body: "b", err: errFailed, checkpoint: "tx-1"},
+			{key: "k", body: "c", result: 2},
+		}, nil, false, ErrKeyReused, 1, ""},
+		{"failed checkpoint expired", []request{
+			{key: "k", body: "b", err: errFailed, checkpoint: "tx-1"},
+			{key: "k", body: "b", after: 2 * time.Hour, result: 2},
+		}, 2, false, nil, 2, ""},
+	}
+
+	for _, test := range tests {
+		start := time.Now()
+		c := NewCache(time.Hour)
+
+		runs, resumed := 0, ""
+		var result interface{}
+		var replayed bool
+		var err error
+		for _, r := range test.requests {
+			r := r
+			c.now = func() time.Time { return start.Add(r.after) }
+			result, replayed, err = c.Do(r.key, []byte(r.body), func(checkpoint *string) (interface{}, error) {
+				runs++
+				resumed = *checkpoint
+				if r.checkpoint != "" {
+					*checkpoint = r.checkpoint
+				}
+				return r.result, r.err
+			})
+		}
+
+		if result != test.result || replayed != test.replayed || err != test.err {
+			t.Errorf("%s: Do() = %v, %v, %v, want %v, %v, %v", test.name, result, replayed, err, test.result, test.replayed, test.err)
+		}
+
+		if runs != test.runs || resumed != test.resumed {
+			t.Errorf("%s: fn ran %d times, last resuming %q, want %d and %q", test.name, runs, resumed, test.runs, test.resumed)
+		}
+	}
+}
+
+func TestDoWaitsForTheFirstRequest(t *testing.T) {
+	c := NewCache(time.Hour)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	runs := 0
+	fn := func(*string) (interface{}, error) {
+		runs++
+		close(started)
+		<-release
+		return "order", nil
+	}
+
+	type outcome struct {
+		result interface{}
+
+		replayed bool
+
+		err error
+	}
+	first := make(chan outcome)
+	go func() {
+		result, replayed, err := c.Do("k", []byte("b"), fn)
+		first <- outcome{result, replayed, err}
+	}()
+	<-started
+
+	retry := make(chan outcome)
+	go func() {
+		result, replayed, err := c.Do("k", []byte("b"), fn)
+		retry <- outcome{result, replayed, err}
+	}()
+
+	select {
+	case <-retry:
+		t.Fatal("the retry returned before the first request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if got := <-first; got.result != "order" || got.replayed || got.err != nil {
+		t.Errorf("first request got %+v, want the order", got)
+	}
+
+	if got := <-retry; got.result != "order" || !got.replayed || got.err != nil {
+		t.Errorf("retry got %+v, want the order replayed", got)
+	}
+
+	if runs != 1 {
+		t.Errorf("fn ran %d times, want 1", runs)
+	}
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidAmount is returned for an amount that isn't a positive number with at most two decimals
+var ErrInvalidAmount = errors.New("Invalid amount")
+
+// parseAmount converts an amount in rupees, like "10" or "10.50", to paise
+func parseAmount(amount string) (int64, error) {
+	amount = strings.TrimSpace(amount)
+	rupees, paise := amount, ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		rupees, paise = amount[:i], amount[i+1:]
+	}
+
+	if rupees == "" || len(paise) > 2 || strings.HasPrefix(rupees, "+") || strings.HasPrefix(rupees, "-") {
+		return 0, ErrInvalidAmount
+	}
+
+	for len(paise) < 2 {
+		paise += "0"
+	}
+
+	total, err := strconv.ParseInt(rupees+paise, 10, 64)
+	if err != nil || total <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	return total, nil
+}
+
+// formatAmount converts paise to an amount in rupees with two decimals
+func formatAmount(paise int64) string {
+	return fmt.Sprintf("%d.%02d", paise/100, paise%100)
+}
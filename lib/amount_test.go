@@ -0,0 +1,57 @@
+package lib
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+
+		paise int64
+
+		err error
+	}{
+		{"10", 1000, nil},
+		{"10.5", 1050, nil},
+		{"10.50", 1050, nil},
+		{" 0.01 ", 1, nil},
+		{"9999999.99", 999999999, nil},
+		{"0", 0, ErrInvalidAmount},
+		{"0.00", 0, ErrInvalidAmount},
+		{"", 0, ErrInvalidAmount},
+		{".50", 0, ErrInvalidAmount},
+		{"10.505", 0, ErrInvalidAmount},
+		{"-10", 0, ErrInvalidAmount},
+		{"+10", 0, ErrInvalidAmount},
+		{"10.-5", 0, ErrInvalidAmount},
+		{"1e3", 0, ErrInvalidAmount},
+		{"ten", 0, ErrInvalidAmount},
+	}
+
+	for _, test := range tests {
+		paise, err := parseAmount(test.amount)
+		if paise != test.paise || err != test.err {
+			t.Errorf("parseAmount(%q) = %d, %v, want %d, %v", test.amount, paise, err, test.paise, test.err)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		paise int64
+
+		amount string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{50, "0.50"},
+		{1000, "10.00"},
+		{1050, "10.50"},
+		{999999999, "9999999.99"},
+	}
+
+	for _, test := range tests {
+		if amount := formatAmount(test.paise); amount != test.amount {
+			t.Errorf("formatAmount(%d) = %q, want %q", test.paise, amount, test.amount)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"context"
+	"sync"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// StatusResult is the outcome of one lookup of a batch: the order or why it couldn't be found
+type StatusResult struct {
+	Order *model.OrderDetails
+
+	Err error
+}
+
+// GetOrderDetailsBatch looks up several orders, at most parallelism at a time. Each lookup
+// has its own result, in the order of queries. An error is only returned when no lookup could start.
+func (c *Client) GetOrderDetailsBatch(ctx context.Context, queries []model.StatusQuery, parallelism int) ([]StatusResult, error) {
+	// Every lookup needs the token, fetched once here rather than by the first lookups all at once
+	if _, err := c.accessToken(ctx); err != nil {
+		return nil, err
+	}
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	results := make([]StatusResult, len(queries))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for i, query := range queries {
+		if err := ValidateStatusQuery("", query.OrderID, query.TransactionID); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, query model.StatusQuery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			order, err := c.GetOrderDetails(ctx, query.OrderID, query.TransactionID)
+			results[i] = StatusResult{Order: order, Err: err}
+		}(i, query)
+	}
+
+	wg.Wait()
+	return results, nil
+}
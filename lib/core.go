@@ -2,105 +2,278 @@ package lib
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"net/url"
-	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/instamojo/sample-sdk-server/config"
 	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
 )
 
-const testENV = "test"
-const prodENV = "production"
+// TestENV is the Instamojo sandbox environment
+const TestENV = "test"
 
-var env string
-var clientID string
-var clientSecret string
-var imojoURL string
-var client http.Client
+// ProdENV is the Instamojo production environment
+const ProdENV = "production"
 
-func init() {
-	client = http.Client{}
-	setDefaultEnvironment()
+const defaultCurrency = "INR"
+
+// ClientConfig holds the settings a Client is built from
+type ClientConfig struct {
+	// MerchantID is the merchant whose credentials the client has
+	MerchantID string
+
+	Env string
+
+	BaseURL string
+
+	ClientID string
+
+	ClientSecret string
+
+	// Salt verifies the webhooks of the environment. Webhooks are rejected when it is empty.
+	Salt string
+
+	// RedirectURL is where buyers land after paying, the Instamojo SDK redirect when empty
+	RedirectURL string
+
+	// Currency of the orders, INR when empty
+	Currency string
+
+	Retry RetryPolicy
+
+	Timeouts Timeouts
+
+	// Store records the orders created through the client
+	Store store.OrderStore
+
+	// Shared is the state the client shares with the others, a new one when nil
+	Shared *Shared
+}
+
+// Shared is the state of the clients of every merchant and environment, which must outlive any one
+// of them. Clients replacing others, e.g. on a configuration reload, are given the same Shared.
+type Shared struct {
+	// refundLocks serializes the refunds of each payment
+	refundLocks keyedMutex
+
+	// changes tells the watchers of an order that a webhook for it arrived
+	changes broker
+}
+
+// NewShared returns the state for a set of clients
+func NewShared() *Shared {
+	return &Shared{}
 }
 
-func setDefaultEnvironment() {
-	// Defaults to test config
-	setEnviroment(testENV)
+// Timeouts are the deadlines for each operation, including retries.
+// A zero value leaves the operation bounded only by the caller's context, except
+// for Token, since a token fetch is shared and doesn't use the caller's context.
+type Timeouts struct {
+	Token time.Duration
+
+	Order time.Duration
+
+	Status time.Duration
+
+	Refund time.Duration
 }
 
-func setEnviroment(newEnv string) {
-	// Don't change if already set
-	if env == newEnv {
-		return
+// Client talks to a single Instamojo environment.
+// A Client is safe for concurrent use; its settings never change once created.
+type Client struct {
+	merchantID   string
+	env          string
+	clientID     string
+	clientSecret string
+	salt         string
+	imojoURL     string
+	redirectURL  string
+	currency     string
+	client       *http.Client
+	tokens       tokenCache
+	retryPolicy  RetryPolicy
+	timeouts     Timeouts
+	store        store.OrderStore
+	shared       *Shared
+}
+
+// NewClient returns a Client for the environment described by cfg
+func NewClient(cfg ClientConfig) *Client {
+	redirectURL := cfg.RedirectURL
+	if redirectURL == "" {
+		redirectURL = cfg.BaseURL + "/integrations/android/redirect/"
+	}
+
+	currency := cfg.Currency
+	if currency == "" {
+		currency = defaultCurrency
 	}
 
-	env = newEnv
-	if env == prodENV {
-		imojoURL = config.Config.ProdURL
-		clientID = config.Config.ProdClientID
-		clientSecret = config.Config.ProdClientSecret
+	shared := cfg.Shared
+	if shared == nil {
+		shared = NewShared()
+	}
 
-	} else {
-		imojoURL = config.Config.TestURL
-		clientID = config.Config.TestClientID
-		clientSecret = config.Config.TestClientSecret
+	return &Client{
+		merchantID:   cfg.MerchantID,
+		env:          cfg.Env,
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		salt:         cfg.Salt,
+		imojoURL:     cfg.BaseURL,
+		redirectURL:  redirectURL,
+		currency:     currency,
+		client:       &http.Client{},
+		retryPolicy:  cfg.Retry,
+		timeouts:     cfg.Timeouts,
+		store:        cfg.Store,
+		shared:       shared,
 	}
 }
 
-func fetchToken() (*model.OAuth2Token, error) {
+// withTimeout bounds ctx by timeout, if one is set
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Env returns the environment the client talks to
+func (c *Client) Env() string {
+	return c.env
+}
+
+// MerchantID returns the merchant the client acts for
+func (c *Client) MerchantID() string {
+	return c.merchantID
+}
+
+func (c *Client) fetchToken(ctx context.Context) (*model.OAuth2Token, error) {
 	log.Println("Fetching new access token")
 	values := url.Values{}
-	values.Set("client_id", clientID)
-	values.Set("client_secret", clientSecret)
+	values.Set("client_id", c.clientID)
+	values.Set("client_secret", c.clientSecret)
 	values.Set("grant_type", "client_credentials")
-	httpRequest, err := http.NewRequest("POST", imojoURL+"/oauth2/token/", bytes.NewBufferString(values.Encode()))
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", c.imojoURL+"/oauth2/token/", bytes.NewBufferString(values.Encode()))
 	if err != nil {
 		return nil, err
 	}
 
 	httpRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpResponse, err := client.Do(httpRequest)
+	httpResponse, err := c.client.Do(httpRequest)
 	if err != nil {
 		return nil, err
 	}
 
 	token := &model.OAuth2Token{}
-	decodeErr := json.NewDecoder(httpResponse.Body).Decode(token)
+	decodeErr := decodeResponse(httpResponse, token)
 	if decodeErr != nil {
 		return nil, decodeErr
 	}
 
+	if token.AccessToken == "" {
+		return nil, &InvalidResponseError{Err: errors.New("token response has no access token")}
+	}
+
 	return token, nil
 }
 
 // CreateOrder will create a new payment order and returns the same
-func CreateOrder(request model.GetOrderIDRequest) (*model.Order, error) {
-	setEnviroment(strings.ToLower(request.Env))
+func (c *Client) CreateOrder(ctx context.Context, request model.GetOrderIDRequest) (*model.Order, error) {
+	var transactionID string
+	return c.CreateOrderOnce(ctx, request, &transactionID)
+}
+
+// CreateOrderOnce is CreateOrder for a request that may be sent again after a failure. The transaction ID
+// of the order is set in *transactionID before anything is sent to Instamojo. When it is already set, by an
+// earlier attempt for the same request, that attempt is finished instead of creating a second gateway order.
+func (c *Client) CreateOrderOnce(ctx context.Context, request model.GetOrderIDRequest, transactionID *string) (*model.Order, error) {
+	ctx, cancel := withTimeout(ctx, c.timeouts.Order)
+	defer cancel()
+
+	resumed := *transactionID != ""
+	if !resumed {
+		*transactionID = uuid.New().String()
+	} else if record, err := c.store.OrderByTransactionID(*transactionID); err == nil {
+		log.Printf("Resuming order with transaction ID %s", *transactionID)
+		return c.finishOrder(ctx, record)
+	} else if err != store.ErrNotFound {
+		return nil, err
+	}
 
 	// Create GatewayOrder
-	gatewayOrderResponse, prErr := createGatewayOrder(request)
+	gatewayOrderResponse, prErr := c.createGatewayOrder(ctx, request, *transactionID, resumed)
 	if prErr != nil {
 		log.Printf("Error %v", prErr)
 		return nil, prErr
 	}
 
+	// Record it before going on, so the transaction ID is never lost even if the next call fails
+	now := time.Now()
+	record := &model.OrderRecord{
+		TransactionID:    gatewayOrderResponse.Order.TransactionID,
+		PaymentRequestID: gatewayOrderResponse.Order.ID,
+		MerchantID:       c.merchantID,
+		Env:              c.env,
+		BuyerName:        request.BuyerName,
+		BuyerEmail:       request.BuyerEmail,
+		BuyerPhone:       request.BuyerPhone,
+		Amount:           request.Amount,
+		Currency:         gatewayOrderResponse.Order.Currency,
+		Description:      request.Description,
+		Status:           gatewayOrderResponse.Order.Status,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err := c.store.SaveOrder(record); err != nil {
+		log.Printf("Error saving order %v", err)
+		return nil, err
+	}
+
+	return c.finishOrder(ctx, record)
+}
+
+// finishOrder creates the order of the stored gateway order, unless an earlier attempt already did
+func (c *Client) finishOrder(ctx context.Context, record *model.OrderRecord) (*model.Order, error) {
+	if record.OrderID != "" {
+		return &model.Order{
+			OrderID: record.OrderID,
+			Name:    record.BuyerName,
+			Email:   record.BuyerEmail,
+			Phone:   record.BuyerPhone,
+			Amount:  record.Amount,
+		}, nil
+	}
+
 	// Create Order
-	order, oErr := createOrderForGWOrder(gatewayOrderResponse.Order.ID)
+	order, oErr := c.createOrderForGWOrder(ctx, record.PaymentRequestID)
 	if oErr != nil {
 		log.Printf("Error %v", oErr)
 		return nil, oErr
 	}
 
+	record.OrderID = order.OrderID
+	record.UpdatedAt = time.Now()
+	if err := c.store.SaveOrder(record); err != nil {
+		log.Printf("Error saving order %v", err)
+		return nil, err
+	}
+
 	log.Printf("Created order with ID %s", order.OrderID)
 	return order, nil
 }
 
-func createGatewayOrder(getOrderIDRequest model.GetOrderIDRequest) (*model.GatewayOrderResponse, error) {
+// createGatewayOrder creates the gateway order of transactionID. When resumed, an earlier
+// attempt may have created it already, so it is looked up first.
+func (c *Client) createGatewayOrder(ctx context.Context, getOrderIDRequest model.GetOrderIDRequest, transactionID string, resumed bool) (*model.GatewayOrderResponse, error) {
 	log.Println("Creating gateway order")
 	gatewayOrder := model.GatewayOrder{}
 	gatewayOrder.Name = getOrderIDRequest.BuyerName
@@ -108,59 +281,89 @@ func createGatewayOrder(getOrderIDRequest model.GetOrderIDRequest) (*model.Gatew
 	gatewayOrder.Phone = getOrderIDRequest.BuyerPhone
 	gatewayOrder.Amount = getOrderIDRequest.Amount
 	gatewayOrder.Description = getOrderIDRequest.Description
-	gatewayOrder.Currency = "INR"
-	gatewayOrder.TransactionID = uuid.New().String()
-	gatewayOrder.RedirectURL = imojoURL + "/integrations/android/redirect/"
+	gatewayOrder.Currency = c.currency
+	gatewayOrder.TransactionID = transactionID
+	gatewayOrder.RedirectURL = c.redirectURL
 
 	jsonPaymentRequest, _ := json.Marshal(gatewayOrder)
-	httpRequest, _ := http.NewRequest("POST", imojoURL+"/v2/gateway/orders/", bytes.NewBuffer(jsonPaymentRequest))
-	token, tErr := fetchToken()
-	if tErr != nil {
-		log.Printf("Error %v", tErr)
-		return nil, tErr
+	var gatewayOrderResponse model.GatewayOrderResponse
+	create := func() error {
+		httpResponse, err := c.do(ctx, func() (*http.Request, error) {
+			httpRequest, err := http.NewRequestWithContext(ctx, "POST", c.imojoURL+"/v2/gateway/orders/", bytes.NewBuffer(jsonPaymentRequest))
+			if err != nil {
+				return nil, err
+			}
+
+			httpRequest.Header.Set("Content-Type", "application/json")
+			return httpRequest, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return decodeResponse(httpResponse, &gatewayOrderResponse)
 	}
 
-	httpRequest.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	httpRequest.Header.Set("Content-Type", "application/json")
+	// The transaction ID is ours, so a lookup tells whether a failed attempt created the order anyway
+	landed := func() (bool, error) {
+		existing, err := c.getGatewayOrder(ctx, "", gatewayOrder.TransactionID)
+		if isNotFound(err) {
+			return false, nil
+		}
 
-	httpResponse, err := client.Do(httpRequest)
+		if err != nil {
+			return false, err
+		}
+
+		gatewayOrderResponse.Order = *existing
+		return true, nil
+	}
+
+	found := false
+	var err error
+	if resumed {
+		found, err = landed()
+	}
+	if err == nil && !found {
+		_, err = c.retryUnlessLanded(ctx, "Gateway order creation", create, landed)
+	}
 	if err != nil {
 		log.Printf("Error %v", err)
 		return nil, err
 	}
 
-	var gatewayOrderResponse model.GatewayOrderResponse
-	decodeErr := json.NewDecoder(httpResponse.Body).Decode(&gatewayOrderResponse)
-	if decodeErr != nil {
-		log.Printf("Decode Error %v", decodeErr)
-		return nil, decodeErr
+	if gatewayOrderResponse.Order.TransactionID == "" {
+		gatewayOrderResponse.Order.TransactionID = gatewayOrder.TransactionID
+	}
+
+	if gatewayOrderResponse.Order.Currency == "" {
+		gatewayOrderResponse.Order.Currency = gatewayOrder.Currency
 	}
 
 	return &gatewayOrderResponse, nil
 }
 
-func createOrderForGWOrder(gatewayOrderID string) (*model.Order, error) {
+func (c *Client) createOrderForGWOrder(ctx context.Context, gatewayOrderID string) (*model.Order, error) {
 	log.Printf("Creating order for gateway order (payment request) ID %s", gatewayOrderID)
 	orderRequest := model.OrderRequest{}
 	orderRequest.PaymentRequestID = gatewayOrderID
 
 	jsonOrderRequest, _ := json.Marshal(orderRequest)
-	httpRequest, _ := http.NewRequest("POST", imojoURL+"/v2/gateway/orders/payment-request/", bytes.NewBuffer(jsonOrderRequest))
-	token, tErr := fetchToken()
-	if tErr != nil {
-		return nil, tErr
-	}
-
-	httpRequest.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	httpRequest.Header.Set("Content-Type", "application/json")
-
-	httpResponse, err := client.Do(httpRequest)
+	httpResponse, err := c.do(ctx, func() (*http.Request, error) {
+		httpRequest, err := http.NewRequestWithContext(ctx, "POST", c.imojoURL+"/v2/gateway/orders/payment-request/", bytes.NewBuffer(jsonOrderRequest))
+		if err != nil {
+			return nil, err
+		}
+
+		httpRequest.Header.Set("Content-Type", "application/json")
+		return httpRequest, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	var createdOrder model.Order
-	decodeErr := json.NewDecoder(httpResponse.Body).Decode(&createdOrder)
+	decodeErr := decodeResponse(httpResponse, &createdOrder)
 	if decodeErr != nil {
 		return nil, decodeErr
 	}
@@ -170,10 +373,11 @@ func createOrderForGWOrder(gatewayOrderID string) (*model.Order, error) {
 
 // GetOrderStatus return the status of the order referencing either orderID or transactionID.
 // Preference will be given to orderID
-func GetOrderStatus(env, orderID, transactionID string) (*model.GatewayOrderStatus, error) {
-	setEnviroment(strings.ToLower(env))
+func (c *Client) GetOrderStatus(ctx context.Context, orderID, transactionID string) (*model.GatewayOrderStatus, error) {
+	ctx, cancel := withTimeout(ctx, c.timeouts.Status)
+	defer cancel()
 
-	gatewayOrder, err := getGatewayOrder(orderID, transactionID)
+	gatewayOrder, err := c.getGatewayOrder(ctx, orderID, transactionID)
 	if err != nil {
 		log.Printf("Error %v", err)
 		return nil, err
@@ -190,97 +394,67 @@ func GetOrderStatus(env, orderID, transactionID string) (*model.GatewayOrderStat
 	return &gatewayOrderStatus, nil
 }
 
-func getGatewayOrder(orderID, transactionID string) (*model.GatewayOrder, error) {
-	orderURL := imojoURL + "/v2/gateway/orders/"
-	if orderID == "" {
-		orderURL += "transaction_id:" + transactionID + "/"
+// GetOrderDetails returns the full status of the order referencing either orderID or transactionID,
+// with every payment attempt. Preference will be given to orderID
+func (c *Client) GetOrderDetails(ctx context.Context, orderID, transactionID string) (*model.OrderDetails, error) {
+	ctx, cancel := withTimeout(ctx, c.timeouts.Status)
+	defer cancel()
 
-	} else {
-		orderURL += "id:" + orderID + "/"
-	}
-
-	orderRequest, err := http.NewRequest("GET", orderURL, nil)
+	gatewayOrder, err := c.getGatewayOrder(ctx, orderID, transactionID)
 	if err != nil {
 		log.Printf("Error %v", err)
 		return nil, err
 	}
 
-	token, tErr := fetchToken()
-	if tErr != nil {
-		log.Printf("Error %v", tErr)
-		return nil, tErr
+	payments := gatewayOrder.Payments
+	if payments == nil {
+		payments = []model.Payment{}
 	}
 
-	orderRequest.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	orderRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	httpResponse, err := client.Do(orderRequest)
-	if err != nil {
-		log.Printf("Error %v", err)
-		return nil, err
-	}
-
-	var gatewayOrder model.GatewayOrder
-	decodeErr := json.NewDecoder(httpResponse.Body).Decode(&gatewayOrder)
-	if decodeErr != nil {
-		log.Printf("Decode Error %v", decodeErr)
-		return nil, decodeErr
-	}
-
-	return &gatewayOrder, nil
+	return &model.OrderDetails{
+		OrderID:       gatewayOrder.ID,
+		TransactionID: gatewayOrder.TransactionID,
+		Status:        gatewayOrder.Status,
+		Amount:        gatewayOrder.Amount,
+		Currency:      gatewayOrder.Currency,
+		BuyerName:     gatewayOrder.Name,
+		BuyerEmail:    gatewayOrder.Email,
+		BuyerPhone:    gatewayOrder.Phone,
+		Description:   gatewayOrder.Description,
+		Payments:      payments,
+	}, nil
 }
 
-//InitiateRefund wil initiate refund for the paymentID for the given with given refund reason
-func InitiateRefund(env, transactionID, amount string) (int, error) {
-	setEnviroment(env)
-
-	gatewayOrder, err := getGatewayOrder("", transactionID)
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
-
-	if gatewayOrder.Success || len(gatewayOrder.Payments) < 1 {
-		return http.StatusBadRequest, errors.New(gatewayOrder.Message)
-	}
-
-	payment := gatewayOrder.Payments[0]
-
-	if payment.Status != "successful" {
-		return http.StatusBadRequest, errors.New("Cannot initiate refund for an Unsuccessful transaction")
-	}
-
-	refundURL := imojoURL + "/v2/payments/" + payment.ID + "/refund/"
-	params := url.Values{}
-	// refundType should be within the following types
-	// RFD: Duplicate/delayed payment.
-	// TNR: Product/service no longer available.
-	// QFL: Customer not satisfied.
-	// QNR: Product lost/damaged.
-	// EWN: Digital download issue.
-	// TAN: Event was canceled/changed.
-	// PTH: Problem not described above.
-	params.Set("type", "PTH")
-	params.Set("refund_amount", amount)
-	params.Set("body", "Refund the amount after test payment")
-
-	refundRequest, err := http.NewRequest("POST", refundURL, bytes.NewBufferString(params.Encode()))
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
+func (c *Client) getGatewayOrder(ctx context.Context, orderID, transactionID string) (*model.GatewayOrder, error) {
+	orderURL := c.imojoURL + "/v2/gateway/orders/"
+	if orderID == "" {
+		orderURL += "transaction_id:" + transactionID + "/"
 
-	token, tErr := fetchToken()
-	if tErr != nil {
-		return 0, tErr
+	} else {
+		orderURL += "id:" + orderID + "/"
 	}
 
-	refundRequest.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	refundRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	httpResponse, err := client.Do(refundRequest)
+	var gatewayOrder model.GatewayOrder
+	err := c.retry(ctx, "Gateway order lookup", func() error {
+		httpResponse, err := c.do(ctx, func() (*http.Request, error) {
+			orderRequest, err := http.NewRequestWithContext(ctx, "GET", orderURL, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			orderRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return orderRequest, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return decodeResponse(httpResponse, &gatewayOrder)
+	})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		log.Printf("Error %v", err)
+		return nil, err
 	}
 
-	return httpResponse.StatusCode, nil
+	return &gatewayOrder, nil
 }
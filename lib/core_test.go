@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+// testUpstream is a fake Instamojo API with a client talking to it. Access tokens are
+// granted as token-1, token-2... and the other requests are served by the handler.
+type testUpstream struct {
+	*httptest.Server
+
+	client *Client
+
+	store *store.MemoryStore
+
+	// tokens counts the access tokens granted
+	tokens int32
+
+	// tokenDelay holds every token request, set it before the first one
+	tokenDelay time.Duration
+}
+
+func newTestUpstream(handler http.HandlerFunc) *testUpstream {
+	u := &testUpstream{store: store.NewMemoryStore()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(u.tokenDelay)
+		n := atomic.AddInt32(&u.tokens, 1)
+		json.NewEncoder(w).Encode(model.OAuth2Token{AccessToken: fmt.Sprintf("token-%d", n), ExpiresIn: 36000})
+	})
+	mux.Handle("/", handler)
+	u.Server = httptest.NewServer(mux)
+
+	u.client = NewClient(ClientConfig{
+		MerchantID:   "merchant",
+		Env:          TestENV,
+		BaseURL:      u.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Salt:         "test-salt",
+		Retry:        RetryPolicy{MaxRetries: 2},
+		Store:        u.store,
+	})
+	return u
+}
+
+// writeTestJSON replies with v as JSON
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// fakeGateway is the order API of Instamojo. Each failure count fails that many of its requests.
+type fakeGateway struct {
+	mu sync.Mutex
+
+	// orders by transaction ID
+	orders map[string]model.GatewayOrder
+
+	// created and ordered count the gateway orders and the orders created
+	created, ordered int
+
+	// lostCreates creates the gateway order but replies with an error
+	lostCreates int
+
+	failedLookups int
+
+	failedOrders int
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	const lookupPrefix = "/v2/gateway/orders/transaction_id:"
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/v2/gateway/orders/":
+		var order model.GatewayOrder
+		json.NewDecoder(r.Body).Decode(&order)
+		g.created++
+		order.ID = fmt.Sprintf("GW%d", g.created)
+		g.orders[order.TransactionID] = order
+
+		if g.lostCreates > 0 {
+			g.lostCreates--
+			writeTestJSON(w, http.StatusInternalServerError, map[string]string{"message": "Server error"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, model.GatewayOrderResponse{Order: order})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, lookupPrefix):
+		if g.failedLookups > 0 {
+			g.failedLookups--
+			writeTestJSON(w, http.StatusServiceUnavailable, map[string]string{"message": "Unavailable"})
+			return
+		}
+
+		order, ok := g.orders[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, lookupPrefix), "/")]
+		if !ok {
+			writeTestJSON(w, http.StatusNotFound, map[string]string{"message": "Order not found"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, order)
+	case r.Method == "POST" && r.URL.Path == "/v2/gateway/orders/payment-request/":
+		if g.failedOrders > 0 {
+			g.failedOrders--
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"message": "Try again"})
+			return
+		}
+
+		var request model.OrderRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		g.ordered++
+		writeTestJSON(w, http.StatusOK, model.Order{OrderID: "ORDER-" + request.PaymentRequestID})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestCreateOrderOnce(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// failures of the gateway, see fakeGateway
+		lostCreates, failedLookups, failedOrders int
+
+		// failures is the number of attempts failing before one succeeds
+		failures int
+	}{
+		{"first attempt", 0, 0, 0, 0},
+		{"order creation failed", 0, 0, 1, 1},
+		{"gateway order reply lost", 1, 3, 0, 1},
+	}
+
+	request := model.GetOrderIDRequest{Env: TestENV, BuyerName: "Buyer", BuyerEmail: "buyer@example.com", BuyerPhone: "9999999999", Amount: "10"}
+	for _, test := range tests {
+		gateway := &fakeGateway{
+			orders:        map[string]model.GatewayOrder{},
+			lostCreates:   test.lostCreates,
+			failedLookups: test.failedLookups,
+			failedOrders:  test.failedOrders,
+		}
+		upstream := newTestUpstream(gateway.ServeHTTP)
+
+		var transactionID, first string
+		var order *model.Order
+		for attempt := 0; ; attempt++ {
+			var err error
+			order, err = upstream.client.CreateOrderOnce(context.Background(), request, &transactionID)
+			if attempt == 0 {
+				first = transactionID
+			}
+
+			if err == nil {
+				if attempt != test.failures {
+					t.Errorf("%s: succeeded after %d failures, want %d", test.name, attempt, test.failures)
+				}
+				break
+			}
+
+			if attempt == test.failures {
+				t.Fatalf("%s: attempt %d failed. Error %v", test.name, attempt+1, err)
+			}
+		}
+
+		if first == "" || transactionID != first {
+			t.Errorf("%s: transaction ID %q then %q, want the same one", test.name, first, transactionID)
+		}
+
+		// Sent again once it succeeded, like a retry whose reply was lost
+		again, err := upstream.client.CreateOrderOnce(context.Background(), request, &transactionID)
+		if err != nil || again.OrderID != order.OrderID {
+			t.Errorf("%s: repeated CreateOrderOnce() = %+v, %v, want order %s", test.name, again, err, order.OrderID)
+		}
+
+		gateway.mu.Lock()
+		if gateway.created != 1 || gateway.ordered != 1 {
+			t.Errorf("%s: %d gateway orders and %d orders created, want 1 of each", test.name, gateway.created, gateway.ordered)
+		}
+		gateway.mu.Unlock()
+
+		record, err := upstream.store.OrderByTransactionID(transactionID)
+		if err != nil || record.OrderID != order.OrderID || record.PaymentRequestID != "GW1" {
+			t.Errorf("%s: stored %+v, %v, want order %s of GW1", test.name, record, err, order.OrderID)
+		}
+
+		upstream.Close()
+	}
+}
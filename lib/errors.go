@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// APIError is an error response from the Instamojo API
+type APIError struct {
+	// StatusCode is the HTTP status Instamojo replied with
+	StatusCode int `json:"-"`
+
+	// Code is the Instamojo error code, e.g. invalid_client. It is empty when Instamojo didn't send one.
+	Code string `json:"code,omitempty"`
+
+	// Message is the human readable error
+	Message string `json:"message,omitempty"`
+
+	// Fields has the messages for each rejected request field
+	Fields map[string][]string `json:"errors,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("instamojo: HTTP %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	if len(e.Fields) > 0 {
+		names := make([]string, 0, len(e.Fields))
+		for name := range e.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		details := make([]string, 0, len(names))
+		for _, name := range names {
+			details = append(details, name+": "+strings.Join(e.Fields[name], ", "))
+		}
+		msg += " (" + strings.Join(details, "; ") + ")"
+	}
+
+	return msg
+}
+
+// Temporary reports whether the same request may succeed if sent again later
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// isNotFound reports whether err is Instamojo saying the resource doesn't exist
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// InvalidResponseError is returned when a successful response of Instamojo can't be read
+type InvalidResponseError struct {
+	Err error
+}
+
+func (e *InvalidResponseError) Error() string {
+	return "instamojo: invalid response: " + e.Err.Error()
+}
+
+// Unwrap returns the decoding error
+func (e *InvalidResponseError) Unwrap() error {
+	return e.Err
+}
+
+// decodeResponse closes the response body after decoding it into v.
+// Non 2xx responses are returned as an *APIError, and bodies that aren't the expected JSON
+// as an *InvalidResponseError. v may be nil when the body isn't needed.
+func decodeResponse(httpResponse *http.Response, v interface{}) error {
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299 {
+		return newAPIError(httpResponse)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	err := json.NewDecoder(httpResponse.Body).Decode(v)
+	if _, ok := err.(net.Error); ok || err == nil {
+		// A connection failing while the body is read is retryable like any network failure
+		return err
+	}
+
+	return &InvalidResponseError{Err: err}
+}
+
+// newAPIError reads Instamojo's error body. The API replies with a few shapes:
+//
+//	{"error": "invalid_client"}
+//	{"success": false, "message": "Order not found"}
+//	{"success": false, "message": {"amount": ["Ensure this value is greater than or equal to 9."]}}
+//	{"amount": ["A valid number is required."]}
+func newAPIError(httpResponse *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: httpResponse.StatusCode}
+
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		apiErr.Message = http.StatusText(httpResponse.StatusCode)
+		return apiErr
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(body, &values); err != nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if apiErr.Message == "" || len(apiErr.Message) > 200 {
+			apiErr.Message = http.StatusText(httpResponse.StatusCode)
+		}
+		return apiErr
+	}
+
+	for key, raw := range values {
+		switch key {
+		case "success":
+		case "error":
+			json.Unmarshal(raw, &apiErr.Code)
+		case "error_description", "detail":
+			json.Unmarshal(raw, &apiErr.Message)
+		case "message":
+			var fields map[string]json.RawMessage
+			if json.Unmarshal(raw, &fields) == nil {
+				for field, messages := range fields {
+					apiErr.addField(field, messages)
+				}
+			} else {
+				json.Unmarshal(raw, &apiErr.Message)
+			}
+		default:
+			apiErr.addField(key, raw)
+		}
+	}
+
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(httpResponse.StatusCode)
+	}
+
+	return apiErr
+}
+
+// addField records the messages for a field, given either as a string or a list of strings
+func (e *APIError) addField(field string, raw json.RawMessage) {
+	var messages []string
+	if json.Unmarshal(raw, &messages) != nil {
+		var message string
+		if json.Unmarshal(raw, &message) != nil {
+			return
+		}
+		messages = []string{message}
+	}
+
+	if e.Fields == nil {
+		e.Fields = map[string][]string{}
+	}
+	e.Fields[field] = append(e.Fields[field], messages...)
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// refundRequested is the status of a refund recorded before it is sent to Instamojo.
+// It holds its amount until Instamojo accepts or rejects the refund.
+const refundRequested = "requested"
+
+// refundFailed is the status of a refund Instamojo rejected or never received
+const refundFailed = "failed"
+
+// requestedRefundGrace is how long a refund Instamojo doesn't know of stays requested,
+// as the request may still be on its way
+const requestedRefundGrace = time.Minute
+
+// ErrRefundExceedsAmount is returned for a refund that would take the refunds of a payment above its amount
+var ErrRefundExceedsAmount = errors.New("Refund exceeds the amount left to refund")
+
+// Refund statuses reported by Instamojo, by how they count in the ledger
+var (
+	completedRefundStatuses = map[string]bool{"refunded": true, "completed": true, "processed": true}
+	failedRefundStatuses    = map[string]bool{refundFailed: true, "rejected": true, "cancelled": true, "canceled": true}
+)
+
+// RefundLedger returns the refunds of the payment of transactionID along with their totals
+func (c *Client) RefundLedger(ctx context.Context, transactionID string) (*model.RefundLedger, error) {
+	ctx, cancel := withTimeout(ctx, c.timeouts.Status)
+	defer cancel()
+
+	gatewayOrder, err := c.getGatewayOrder(ctx, "", transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := capturedPayment(gatewayOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	// Held like InitiateRefund does, so the requested refunds looked up aren't being sent
+	unlock := c.shared.refundLocks.lock(payment.ID)
+	defer unlock()
+
+	ledger, _, err := c.refundLedger(ctx, transactionID, payment.ID, gatewayOrder.Amount)
+	return ledger, err
+}
+
+// refundLedger totals the stored refunds of paymentID. It also returns the amount in paise
+// that can still be refunded. Refunds left requested by an earlier failure are looked up first,
+// so the amount they hold is released when they never reached Instamojo.
+// The refunds of paymentID must be locked.
+func (c *Client) refundLedger(ctx context.Context, transactionID, paymentID, capturedAmount string) (*model.RefundLedger, int64, error) {
+	captured, err := parseAmount(capturedAmount)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	refunds, err := c.store.RefundsByPaymentID(paymentID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var requested, pending, completed int64
+	for _, refund := range refunds {
+		amount, err := parseAmount(refund.RefundAmount)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if refund.Status == refundRequested {
+			if err := c.settleRequestedRefund(ctx, refund); err != nil {
+				log.Printf("Refund %s could not be looked up, its amount stays held. Error %v", refund.TransactionID, err)
+			}
+		}
+
+		status := strings.ToLower(refund.Status)
+		switch {
+		case failedRefundStatuses[status]:
+		case completedRefundStatuses[status]:
+			completed += amount
+		case status == refundRequested:
+			requested += amount
+		default:
+			pending += amount
+		}
+	}
+
+	available := captured - requested - pending - completed
+	if available < 0 {
+		available = 0
+	}
+
+	ledger := &model.RefundLedger{
+		TransactionID:   transactionID,
+		PaymentID:       paymentID,
+		CapturedAmount:  formatAmount(captured),
+		RequestedAmount: formatAmount(requested),
+		PendingAmount:   formatAmount(pending),
+		CompletedAmount: formatAmount(completed),
+		AvailableAmount: formatAmount(available),
+		Refunds:         refunds,
+	}
+
+	return ledger, available, nil
+}
+
+// capturedPayment returns the successful payment of the order
+func capturedPayment(gatewayOrder *model.GatewayOrder) (*model.Payment, error) {
+	if len(gatewayOrder.Payments) < 1 {
+		return nil, ErrNoPayment
+	}
+
+	for i := range gatewayOrder.Payments {
+		if gatewayOrder.Payments[i].Status == paymentSuccessful {
+			return &gatewayOrder.Payments[i], nil
+		}
+	}
+
+	return nil, ErrPaymentNotSuccessful
+}
+
+// keyedMutex serializes work on the same key, like the refunds of one payment
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock locks key and returns the function unlocking it
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyedLock{}
+	}
+
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.waiters++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
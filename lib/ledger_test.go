@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+func TestRefundLedger(t *testing.T) {
+	upstream := newTestUpstream(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/gateway/orders/transaction_id:order-1/":
+			writeTestJSON(w, http.StatusOK, model.GatewayOrder{
+				ID:            "GW1",
+				Amount:        "100.00",
+				TransactionID: "order-1",
+				Payments:      []model.Payment{{ID: "MOJO1", Status: paymentFailed}, {ID: "MOJO2", Status: paymentSuccessful}},
+			})
+		case "/v2/resources/refunds/":
+			// Only the refund sent before the lost response reached Instamojo
+			var refunds model.RefundList
+			if r.URL.Query().Get("transaction_id") == "refund-landed" {
+				refunds.Refunds = []model.Refund{{ID: "C2", PaymentID: "MOJO2", TransactionID: "refund-landed", Status: "pending"}}
+			}
+			writeTestJSON(w, http.StatusOK, refunds)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer upstream.Close()
+
+	old := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	recent := time.Now().Format(time.RFC3339)
+	refunds := []*model.Refund{
+		{ID: "C1", TransactionID: "refund-completed", Status: "refunded", RefundAmount: "10"},
+		{ID: "C3", TransactionID: "refund-rejected", Status: "Rejected", RefundAmount: "5"},
+		{ID: "C4", TransactionID: "refund-pending", Status: "pending", RefundAmount: "20"},
+		{TransactionID: "refund-landed", Status: refundRequested, RefundAmount: "15", CreatedAt: old},
+		{TransactionID: "refund-lost", Status: refundRequested, RefundAmount: "7.50", CreatedAt: old},
+		{TransactionID: "refund-in-flight", Status: refundRequested, RefundAmount: "2.50", CreatedAt: recent},
+	}
+	for _, refund := range refunds {
+		refund.PaymentID = "MOJO2"
+		if err := upstream.store.SaveRefund(refund); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ledger, err := upstream.client.RefundLedger(context.Background(), "order-1")
+	if err != nil {
+		t.Fatalf("RefundLedger() error %v", err)
+	}
+
+	totals := []struct {
+		name string
+
+		got, want string
+	}{
+		{"captured", ledger.CapturedAmount, "100.00"},
+		{"requested", ledger.RequestedAmount, "2.50"},
+		{"pending", ledger.PendingAmount, "35.00"},
+		{"completed", ledger.CompletedAmount, "10.00"},
+		{"available", ledger.AvailableAmount, "52.50"},
+	}
+	for _, total := range totals {
+		if total.got != total.want {
+			t.Errorf("%s amount %s, want %s", total.name, total.got, total.want)
+		}
+	}
+
+	if ledger.PaymentID != "MOJO2" {
+		t.Errorf("payment %s, want MOJO2", ledger.PaymentID)
+	}
+
+	statuses := map[string]string{
+		"refund-landed":    "pending",
+		"refund-lost":      refundFailed,
+		"refund-in-flight": refundRequested,
+	}
+	stored, err := upstream.store.RefundsByPaymentID("MOJO2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, refund := range stored {
+		if want, ok := statuses[refund.TransactionID]; ok && refund.Status != want {
+			t.Errorf("refund %s is stored %s, want %s", refund.TransactionID, refund.Status, want)
+		}
+	}
+}
+
+func TestRefundLedgerUnpaid(t *testing.T) {
+	upstream := newTestUpstream(func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, model.GatewayOrder{ID: "GW1", Amount: "100.00"})
+	})
+	defer upstream.Close()
+
+	if _, err := upstream.client.RefundLedger(context.Background(), "order-1"); err != ErrNoPayment {
+		t.Errorf("RefundLedger() error %v, want %v", err, ErrNoPayment)
+	}
+}
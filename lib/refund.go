@@ -0,0 +1,272 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+// RefundTypes are the refund types Instamojo accepts, with their description
+var RefundTypes = map[string]string{
+	"RFD": "Duplicate/delayed payment.",
+	"TNR": "Product/service no longer available.",
+	"QFL": "Customer not satisfied.",
+	"QNR": "Product lost/damaged.",
+	"EWN": "Digital download issue.",
+	"TAN": "Event was canceled/changed.",
+	"PTH": "Problem not described above.",
+}
+
+const defaultRefundType = "PTH"
+
+// ErrInvalidRefundType is returned for a refund type not in RefundTypes
+var ErrInvalidRefundType = errors.New("Invalid refund type")
+
+// ErrNoPayment is returned when refunding an order that has no payment
+var ErrNoPayment = errors.New("No payment found for the transaction")
+
+// ErrPaymentNotSuccessful is returned when refunding an order that has no successful payment
+var ErrPaymentNotSuccessful = errors.New("Cannot initiate refund for an Unsuccessful transaction")
+
+// InitiateRefund wil initiate refund for the paymentID for the given with given refund reason.
+// refundType must be one of RefundTypes, PTH is used when it is empty.
+// The reason defaults to the description of the refund type.
+func (c *Client) InitiateRefund(ctx context.Context, transactionID, amount, refundType, reason string) (*model.Refund, error) {
+	if refundType == "" {
+		refundType = defaultRefundType
+	}
+
+	if _, ok := RefundTypes[refundType]; !ok {
+		return nil, ErrInvalidRefundType
+	}
+
+	if reason == "" {
+		reason = RefundTypes[refundType]
+	}
+
+	refundPaise, err := parseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	amount = formatAmount(refundPaise)
+
+	ctx, cancel := withTimeout(ctx, c.timeouts.Refund)
+	defer cancel()
+
+	gatewayOrder, err := c.getGatewayOrder(ctx, "", transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := capturedPayment(gatewayOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refunds of a payment are checked against the ledger one at a time,
+	// so two concurrent refunds can't both take the last of the amount
+	unlock := c.shared.refundLocks.lock(payment.ID)
+	defer unlock()
+
+	_, available, err := c.refundLedger(ctx, transactionID, payment.ID, gatewayOrder.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if refundPaise > available {
+		return nil, ErrRefundExceedsAmount
+	}
+
+	refundTransactionID := uuid.New().String()
+	record := model.Refund{
+		PaymentID:     payment.ID,
+		TransactionID: refundTransactionID,
+		Status:        refundRequested,
+		Type:          refundType,
+		Body:          reason,
+		RefundAmount:  amount,
+		CreatedAt:     time.Now().Format(time.RFC3339),
+	}
+	if err := c.store.SaveRefund(&record); err != nil {
+		return nil, err
+	}
+
+	refundURL := c.imojoURL + "/v2/payments/" + payment.ID + "/refund/"
+	params := url.Values{}
+	params.Set("type", refundType)
+	params.Set("refund_amount", amount)
+	params.Set("body", reason)
+	// Instamojo rejects a second refund with the same transaction_id
+	params.Set("transaction_id", refundTransactionID)
+
+	var refundResponse model.RefundResponse
+	refund := func() error {
+		httpResponse, err := c.do(ctx, func() (*http.Request, error) {
+			refundRequest, err := http.NewRequestWithContext(ctx, "POST", refundURL, bytes.NewBufferString(params.Encode()))
+			if err != nil {
+				return nil, err
+			}
+
+			refundRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return refundRequest, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return decodeResponse(httpResponse, &refundResponse)
+	}
+
+	landed := func() (bool, error) {
+		existing, err := c.findRefund(ctx, payment.ID, refundTransactionID)
+		if err != nil {
+			return false, err
+		}
+
+		if existing == nil {
+			return false, nil
+		}
+
+		refundResponse.Refund = *existing
+		return true, nil
+	}
+
+	if neverLanded, err := c.retryUnlessLanded(ctx, "Refund", refund, landed); err != nil {
+		// A rejected refund, or one Instamojo never got, releases its amount. After any other
+		// failure the refund may still have gone through, so its amount stays held until the
+		// ledger looks it up again.
+		if apiErr, ok := err.(*APIError); neverLanded || (ok && !apiErr.Temporary()) {
+			record.Status = refundFailed
+			if err := c.store.SaveRefund(&record); err != nil {
+				log.Printf("Error saving refund %v", err)
+			}
+		}
+
+		return nil, err
+	}
+
+	acceptRefund(&record, &refundResponse.Refund)
+	if err := c.store.SaveRefund(&record); err != nil {
+		log.Printf("Error saving refund %v", err)
+	}
+
+	return &record, nil
+}
+
+// acceptRefund updates the record of a refund with the refund Instamojo accepted,
+// keeping what was asked for where Instamojo didn't echo it back
+func acceptRefund(record, accepted *model.Refund) {
+	record.ID = accepted.ID
+	record.Status = accepted.Status
+	if record.Status == "" {
+		record.Status = "pending"
+	}
+	if accepted.CreatedAt != "" {
+		record.CreatedAt = accepted.CreatedAt
+	}
+	record.TotalAmount = accepted.TotalAmount
+}
+
+// settleRequestedRefund looks up a refund left requested by a failure that may or may not have
+// reached Instamojo. It is updated with the refund Instamojo has, or marked failed when Instamojo
+// has none and the request is older than requestedRefundGrace.
+func (c *Client) settleRequestedRefund(ctx context.Context, record *model.Refund) error {
+	existing, err := c.findRefund(ctx, record.PaymentID, record.TransactionID)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		acceptRefund(record, existing)
+	} else {
+		created, err := time.Parse(time.RFC3339, record.CreatedAt)
+		if err == nil && time.Since(created) < requestedRefundGrace {
+			return nil
+		}
+
+		log.Printf("Refund %s never reached Instamojo", record.TransactionID)
+		record.Status = refundFailed
+	}
+
+	return c.store.SaveRefund(record)
+}
+
+// GetRefund returns the refund with the Instamojo refundID, with its current status.
+// The stored refund is updated with the status.
+func (c *Client) GetRefund(ctx context.Context, refundID string) (*model.Refund, error) {
+	ctx, cancel := withTimeout(ctx, c.timeouts.Status)
+	defer cancel()
+
+	refundURL := c.imojoURL + "/v2/resources/refunds/" + url.PathEscape(refundID) + "/"
+
+	var current model.Refund
+	err := c.retry(ctx, "Refund lookup", func() error {
+		httpResponse, err := c.do(ctx, func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", refundURL, nil)
+		})
+		if err != nil {
+			return err
+		}
+
+		return decodeResponse(httpResponse, &current)
+	})
+	if err != nil {
+		log.Printf("Error %v", err)
+		return nil, err
+	}
+
+	record, err := c.store.RefundByID(refundID)
+	if err == store.ErrNotFound {
+		return &current, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if current.Status != "" && current.Status != record.Status {
+		log.Printf("Refund %s is now %s", refundID, current.Status)
+		record.Status = current.Status
+		if err := c.store.SaveRefund(record); err != nil {
+			log.Printf("Error saving refund %v", err)
+		}
+	}
+
+	return record, nil
+}
+
+// findRefund looks up the refund of paymentID created with transactionID. It returns nil if there is none.
+func (c *Client) findRefund(ctx context.Context, paymentID, transactionID string) (*model.Refund, error) {
+	refundsURL := c.imojoURL + "/v2/resources/refunds/?" + url.Values{"transaction_id": {transactionID}}.Encode()
+
+	var refundList model.RefundList
+	err := c.retry(ctx, "Refund lookup", func() error {
+		httpResponse, err := c.do(ctx, func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", refundsURL, nil)
+		})
+		if err != nil {
+			return err
+		}
+
+		return decodeResponse(httpResponse, &refundList)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, refund := range refundList.Refunds {
+		if refund.TransactionID == transactionID && refund.PaymentID == paymentID {
+			return &refund, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how failed calls to Instamojo are retried
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt. Zero disables retries.
+	MaxRetries int
+
+	// BaseDelay is the backoff before the first retry. It doubles with every retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the backoff between two attempts
+	MaxDelay time.Duration
+}
+
+// delay returns a random backoff of up to BaseDelay * 2^(retry-1), capped at MaxDelay.
+// The jitter keeps many failed requests from retrying in lockstep.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	backoff := p.BaseDelay << uint(retry-1)
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff))) + 1
+}
+
+// retryable reports whether err is a network failure or a temporary Instamojo error.
+// Nothing is retryable once ctx is done, as the caller has given up or run out of time.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.Temporary()
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// retry calls fn until it succeeds, fails with an error that is not retryable, or the
+// policy runs out of retries. Only use it for calls that are safe to send twice.
+func (c *Client) retry(ctx context.Context, operation string, fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt <= c.retryPolicy.MaxRetries && err != nil && retryable(ctx, err); attempt++ {
+		wait := c.retryPolicy.delay(attempt)
+		log.Printf("%s failed, retrying in %v. Error %v", operation, wait, err)
+		if !sleep(ctx, wait) {
+			return err
+		}
+		err = fn()
+	}
+
+	return err
+}
+
+// retryUnlessLanded is retry for calls that must not be sent twice, like creating an order or a refund.
+// A failed attempt may still have reached Instamojo, so before each retry landed looks the
+// request up by its transaction ID. The call is only sent again when it never landed.
+// When it fails, neverLanded reports whether landed found that the last attempt didn't reach Instamojo.
+func (c *Client) retryUnlessLanded(ctx context.Context, operation string, fn func() error, landed func() (bool, error)) (neverLanded bool, err error) {
+	err = fn()
+	for attempt := 1; err != nil && retryable(ctx, err); attempt++ {
+		found, lookupErr := landed()
+		if lookupErr != nil {
+			log.Printf("%s failed and could not be looked up, not retrying. Error %v", operation, lookupErr)
+			return false, err
+		}
+
+		if found {
+			log.Printf("%s reached Instamojo despite error %v", operation, err)
+			return false, nil
+		}
+
+		if attempt > c.retryPolicy.MaxRetries {
+			return true, err
+		}
+
+		wait := c.retryPolicy.delay(attempt)
+		log.Printf("%s failed, retrying in %v. Error %v", operation, wait, err)
+		if !sleep(ctx, wait) {
+			return true, err
+		}
+		err = fn()
+	}
+
+	return false, err
+}
+
+// sleep waits for d, returning false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
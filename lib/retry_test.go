@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryUnlessLanded(t *testing.T) {
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+	invalid := &APIError{StatusCode: http.StatusBadRequest}
+	lookupFailed := errors.New("lookup failed")
+
+	tests := []struct {
+		name string
+
+		// results of the attempts, the last one repeating
+		results []error
+
+		// found is what landed answers
+		found bool
+
+		lookupErr error
+
+		err error
+
+		neverLanded bool
+
+		attempts int
+
+		lookups int
+	}{
+		{"succeeds", []error{nil}, false, nil, nil, false, 1, 0},
+		{"succeeds on retry", []error{unavailable, nil}, false, nil, nil, false, 2, 1},
+		{"landed despite error", []error{unavailable}, true, nil, nil, false, 1, 1},
+		{"never lands", []error{unavailable}, false, nil, unavailable, true, 3, 3},
+		{"not retryable", []error{invalid}, false, nil, invalid, false, 1, 0},
+		{"lookup fails", []error{unavailable}, false, lookupFailed, unavailable, false, 1, 1},
+	}
+
+	for _, test := range tests {
+		c := &Client{retryPolicy: RetryPolicy{MaxRetries: 2}}
+
+		attempts, lookups := 0, 0
+		fn := func() error {
+			attempts++
+			if attempts > len(test.results) {
+				return test.results[len(test.results)-1]
+			}
+			return test.results[attempts-1]
+		}
+		landed := func() (bool, error) {
+			lookups++
+			return test.found, test.lookupErr
+		}
+
+		neverLanded, err := c.retryUnlessLanded(context.Background(), test.name, fn, landed)
+		if err != test.err || neverLanded != test.neverLanded {
+			t.Errorf("%s: retryUnlessLanded() = %v, %v, want %v, %v", test.name, neverLanded, err, test.neverLanded, test.err)
+		}
+
+		if attempts != test.attempts || lookups != test.lookups {
+			t.Errorf("%s: %d attempts and %d lookups, want %d and %d", test.name, attempts, lookups, test.attempts, test.lookups)
+		}
+	}
+}
+
+func TestRetryUnlessLandedCancelled(t *testing.T) {
+	c := &Client{retryPolicy: RetryPolicy{MaxRetries: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+	attempts := 0
+	fn := func() error {
+		attempts++
+		return unavailable
+	}
+	landed := func() (bool, error) {
+		// Given up on while the first attempt is looked up
+		cancel()
+		return false, nil
+	}
+
+	neverLanded, err := c.retryUnlessLanded(ctx, "cancelled", fn, landed)
+	if err != unavailable || !neverLanded || attempts != 1 {
+		t.Errorf("retryUnlessLanded() = %v, %v after %d attempts, want true, %v after 1", neverLanded, err, attempts, unavailable)
+	}
+}
@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// tokenExpiryMargin is how long before its expiry a cached token is refreshed,
+// so a token never expires while a request using it is in flight.
+// Tokens living less than four margins are refreshed after three quarters of their lifetime instead.
+const tokenExpiryMargin = 60 * time.Second
+
+// defaultTokenTimeout bounds a token fetch when the client has no token timeout. The fetch is shared
+// and outlives the callers' contexts, so a hung fetch would otherwise block every request for good.
+const defaultTokenTimeout = 30 * time.Second
+
+// tokenCache keeps the access token of a single Client.
+// Concurrent callers that find the token stale share a single refresh.
+type tokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	refresh *tokenRefresh
+}
+
+// tokenRefresh is a token fetch in progress
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// get returns a valid access token, calling fetch when the cached one is missing or stale.
+// The fetch is shared by every waiting caller, so it runs independently of ctx:
+// a caller giving up stops waiting for it without cancelling it for the others.
+func (t *tokenCache) get(ctx context.Context, fetch func() (*model.OAuth2Token, error)) (string, error) {
+	t.mu.Lock()
+	if t.token != "" && time.Now().Before(t.expires) {
+		token := t.token
+		t.mu.Unlock()
+		return token, nil
+	}
+
+	refresh := t.refresh
+	if refresh == nil {
+		refresh = &tokenRefresh{done: make(chan struct{})}
+		t.refresh = refresh
+		go t.run(refresh, fetch)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.token, refresh.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// run fetches a token for refresh and stores it in the cache
+func (t *tokenCache) run(refresh *tokenRefresh, fetch func() (*model.OAuth2Token, error)) {
+	token, err := fetch()
+
+	t.mu.Lock()
+	if err == nil {
+		t.token = token.AccessToken
+		t.expires = tokenExpiry(time.Now(), token.ExpiresIn)
+		refresh.token = token.AccessToken
+	}
+	refresh.err = err
+	t.refresh = nil
+	t.mu.Unlock()
+
+	close(refresh.done)
+}
+
+// tokenExpiry returns when a token fetched at now and valid for expiresIn seconds is refreshed
+func tokenExpiry(now time.Time, expiresIn int) time.Time {
+	lifetime := time.Duration(expiresIn) * time.Second
+	margin := tokenExpiryMargin
+	if margin > lifetime/4 {
+		margin = lifetime / 4
+	}
+	return now.Add(lifetime - margin)
+}
+
+// invalidate drops the cached token if it is still the rejected one.
+// A token refreshed by another request in the meantime is kept.
+func (t *tokenCache) invalidate(rejected string) {
+	t.mu.Lock()
+	if t.token == rejected {
+		t.token = ""
+	}
+	t.mu.Unlock()
+}
+
+// accessToken returns the client's access token, fetching a new one only when needed
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	return c.tokens.get(ctx, func() (*model.OAuth2Token, error) {
+		timeout := c.timeouts.Token
+		if timeout <= 0 {
+			timeout = defaultTokenTimeout
+		}
+
+		fetchCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var token *model.OAuth2Token
+		err := c.retry(fetchCtx, "Token fetch", func() error {
+			var err error
+			token, err = c.fetchToken(fetchCtx)
+			return err
+		})
+		return token, err
+	})
+}
+
+// CheckToken checks the client can get an access token. A cached token is enough,
+// so Instamojo is only called when the cached one is missing or expired.
+func (c *Client) CheckToken(ctx context.Context) error {
+	_, err := c.accessToken(ctx)
+	return err
+}
+
+// do sends the request built by newRequest with the cached access token.
+// If Instamojo rejects the token, it is refreshed and the request is sent once more,
+// which is why the request is built by a function rather than passed in.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResponse, err := c.send(newRequest, token)
+	if err != nil || httpResponse.StatusCode != http.StatusUnauthorized {
+		return httpResponse, err
+	}
+
+	httpResponse.Body.Close()
+	log.Println("Access token rejected, fetching a new one")
+	c.tokens.invalidate(token)
+
+	token, err = c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.send(newRequest, token)
+}
+
+func (c *Client) send(newRequest func() (*http.Request, error), token string) (*http.Response, error) {
+	httpRequest, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest.Header.Set("Authorization", "Bearer "+token)
+	return c.client.Do(httpRequest)
+}
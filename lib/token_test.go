@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+func TestTokenRefreshIsShared(t *testing.T) {
+	upstream := newTestUpstream(http.NotFound)
+	defer upstream.Close()
+	upstream.tokenDelay = 50 * time.Millisecond
+
+	const callers = 20
+	tokens := make(chan string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := upstream.client.accessToken(context.Background())
+			if err != nil {
+				t.Errorf("accessToken() error %v", err)
+			}
+			tokens <- token
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	for token := range tokens {
+		if token != "token-1" {
+			t.Errorf("accessToken() = %q, want token-1", token)
+		}
+	}
+
+	if _, err := upstream.client.accessToken(context.Background()); err != nil {
+		t.Fatalf("accessToken() error %v", err)
+	}
+
+	if n := atomic.LoadInt32(&upstream.tokens); n != 1 {
+		t.Errorf("%d tokens fetched, want 1", n)
+	}
+}
+
+func TestRejectedTokenIsRefreshed(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// rejected reports whether the API rejects a token
+		rejected func(token string) bool
+
+		status int
+
+		requests int32
+
+		tokens int32
+	}{
+		{"accepted", func(string) bool { return false }, http.StatusOK, 1, 1},
+		{"rejected once", func(token string) bool { return token == "token-1" }, http.StatusOK, 2, 2},
+		{"always rejected", func(string) bool { return true }, http.StatusUnauthorized, 2, 2},
+	}
+
+	for _, test := range tests {
+		var requests int32
+		upstream := newTestUpstream(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if test.rejected(r.Header.Get("Authorization")[len("Bearer "):]) {
+				writeTestJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+				return
+			}
+			writeTestJSON(w, http.StatusOK, model.GatewayOrder{ID: "GW1"})
+		})
+
+		order, err := upstream.client.getGatewayOrder(context.Background(), "GW1", "")
+		status := http.StatusOK
+		if apiErr, ok := err.(*APIError); ok {
+			status = apiErr.StatusCode
+		} else if err != nil {
+			t.Errorf("%s: getGatewayOrder() error %v", test.name, err)
+		} else if order.ID != "GW1" {
+			t.Errorf("%s: getGatewayOrder() = %+v, want order GW1", test.name, order)
+		}
+
+		if status != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.status)
+		}
+
+		if n := atomic.LoadInt32(&requests); n != test.requests {
+			t.Errorf("%s: %d requests sent, want %d", test.name, n, test.requests)
+		}
+
+		if n := atomic.LoadInt32(&upstream.tokens); n != test.tokens {
+			t.Errorf("%s: %d tokens fetched, want %d", test.name, n, test.tokens)
+		}
+
+		upstream.Close()
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expiresIn int
+
+		refreshAfter time.Duration
+	}{
+		{36000, 36000*time.Second - tokenExpiryMargin},
+		{240, 180 * time.Second},
+		{60, 45 * time.Second},
+		{1, 750 * time.Millisecond},
+		{0, 0},
+	}
+
+	for _, test := range tests {
+		if expiry := tokenExpiry(now, test.expiresIn); expiry.Sub(now) != test.refreshAfter {
+			t.Errorf("tokenExpiry(now, %d) = now + %v, want now + %v", test.expiresIn, expiry.Sub(now), test.refreshAfter)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// Limits of the order fields accepted by Instamojo
+const (
+	minOrderAmount       = 900      // paise
+	maxOrderAmount       = 20000000 // paise
+	maxBuyerNameLength   = 100
+	maxDescriptionLength = 255
+)
+
+var (
+	// Indian mobile numbers, optionally prefixed with +91, 91 or 0
+	mobilePattern = regexp.MustCompile(`^(\+91|91|0)?[6-9][0-9]{9}$`)
+
+	// Order, transaction and refund IDs end up in Instamojo URLs, so only URL safe characters are allowed
+	idPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+
+	// Separators people type inside phone numbers
+	phoneSeparators = strings.NewReplacer(" ", "", "-", "")
+)
+
+// ValidationError lists the rejected fields of a request, with the reasons for each
+type ValidationError struct {
+	Fields map[string][]string
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	details := make([]string, 0, len(names))
+	for _, name := range names {
+		details = append(details, name+": "+strings.Join(e.Fields[name], ", "))
+	}
+
+	return "Invalid request (" + strings.Join(details, "; ") + ")"
+}
+
+func (e *ValidationError) add(field, message string) {
+	if e.Fields == nil {
+		e.Fields = map[string][]string{}
+	}
+	e.Fields[field] = append(e.Fields[field], message)
+}
+
+// err returns e as an error, or nil when no field was rejected
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateOrderRequest checks an order before it is sent to Instamojo. It returns the order
+// as it must be sent, with the name and description trimmed and the separators removed from the
+// phone number, or a *ValidationError listing every rejected field.
+func ValidateOrderRequest(request model.GetOrderIDRequest) (model.GetOrderIDRequest, error) {
+	v := &ValidationError{}
+	validateEnv(v, request.Env)
+
+	request.BuyerName = strings.TrimSpace(request.BuyerName)
+	if request.BuyerName == "" {
+		v.add("buyer_name", "is required")
+	} else if utf8.RuneCountInString(request.BuyerName) > maxBuyerNameLength {
+		v.add("buyer_name", "must be at most 100 characters")
+	}
+
+	if request.BuyerEmail == "" {
+		v.add("buyer_email", "is required")
+	} else if address, err := mail.ParseAddress(request.BuyerEmail); err != nil || address.Address != request.BuyerEmail {
+		v.add("buyer_email", "must be a valid email address")
+	}
+
+	request.BuyerPhone = phoneSeparators.Replace(request.BuyerPhone)
+	if request.BuyerPhone == "" {
+		v.add("buyer_phone", "is required")
+	} else if !mobilePattern.MatchString(request.BuyerPhone) {
+		v.add("buyer_phone", "must be a 10 digit Indian mobile number")
+	}
+
+	if amount, err := parseAmount(request.Amount); err != nil {
+		v.add("amount", "must be a number with at most two decimals")
+	} else if amount < minOrderAmount || amount > maxOrderAmount {
+		v.add("amount", "must be between 9.00 and 200000.00")
+	}
+
+	request.Description = strings.TrimSpace(request.Description)
+	if request.Description == "" {
+		v.add("description", "is required")
+	} else if utf8.RuneCountInString(request.Description) > maxDescriptionLength {
+		v.add("description", "must be at most 255 characters")
+	}
+
+	return request, v.err()
+}
+
+// ValidateStatusQuery checks the parameters of an order status lookup.
+// Either orderID or transactionID is required.
+func ValidateStatusQuery(env, orderID, transactionID string) error {
+	v := &ValidationError{}
+	validateEnv(v, env)
+
+	if orderID == "" && transactionID == "" {
+		v.add("order_id", "order_id or transaction_id is required")
+	}
+
+	if orderID != "" {
+		validateID(v, "order_id", orderID)
+	}
+
+	if transactionID != "" {
+		validateID(v, "transaction_id", transactionID)
+	}
+
+	return v.err()
+}
+
+// ValidateStatusBatch checks the size and env of a batch of status lookups. The lookups
+// themselves are checked one by one, so that each invalid one only fails itself.
+func ValidateStatusBatch(request model.StatusBatchRequest, maxSize int) error {
+	v := &ValidationError{}
+	validateEnv(v, request.Env)
+
+	if len(request.Orders) == 0 {
+		v.add("orders", "is required")
+	} else if len(request.Orders) > maxSize {
+		v.add("orders", "must have at most "+strconv.Itoa(maxSize)+" orders")
+	}
+
+	return v.err()
+}
+
+// ValidateRefundRequest checks the parameters of a refund. refundType may be empty for the default type.
+func ValidateRefundRequest(env, transactionID, amount, refundType string) error {
+	v := &ValidationError{}
+	validateEnv(v, env)
+	validateRequiredID(v, "transaction_id", transactionID)
+
+	if _, err := parseAmount(amount); err != nil {
+		v.add("amount", "must be a positive number with at most two decimals")
+	}
+
+	if _, ok := RefundTypes[refundType]; refundType != "" && !ok {
+		v.add("type", "must be one of RFD, TNR, QFL, QNR, EWN, TAN or PTH")
+	}
+
+	return v.err()
+}
+
+// ValidateLookup checks the env and the ID of a lookup by a single ID, like a refund or a ledger
+func ValidateLookup(env, field, id string) error {
+	v := &ValidationError{}
+	validateEnv(v, env)
+	validateRequiredID(v, field, id)
+	return v.err()
+}
+
+// validateEnv accepts test and production. An empty env is test.
+func validateEnv(v *ValidationError, env string) {
+	switch strings.ToLower(env) {
+	case "", TestENV, ProdENV:
+	default:
+		v.add("env", "must be test or production")
+	}
+}
+
+func validateRequiredID(v *ValidationError, field, id string) {
+	if id == "" {
+		v.add(field, "is required")
+		return
+	}
+
+	validateID(v, field, id)
+}
+
+func validateID(v *ValidationError, field, id string) {
+	if !idPattern.MatchString(id) {
+		v.add(field, "must be at most 64 letters, digits, '-' or '_'")
+	}
+}
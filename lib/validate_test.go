@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// rejected returns the fields of a *ValidationError as sorted "field: messages" entries joined by "; ",
+// empty when err is nil
+func rejected(t *testing.T, err error) string {
+	if err == nil {
+		return ""
+	}
+
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("error %v is not a *ValidationError", err)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(validationErr.Error(), "Invalid request ("), ")")
+}
+
+func TestValidateOrderRequest(t *testing.T) {
+	valid := model.GetOrderIDRequest{
+		Env:         "test",
+		BuyerName:   "Buyer",
+		BuyerEmail:  "buyer@example.com",
+		BuyerPhone:  "9876543210",
+		Amount:      "10.50",
+		Description: "Book",
+	}
+
+	tests := []struct {
+		name string
+
+		change func(r *model.GetOrderIDRequest)
+
+		// fields rejected, as returned by rejected
+		fields string
+
+		// sent is checked against the order returned when there's no error
+		sent func(r *model.GetOrderIDRequest)
+	}{
+		{"valid", func(r *model.GetOrderIDRequest) {}, "", func(r *model.GetOrderIDRequest) {}},
+		{"production", func(r *model.GetOrderIDRequest) { r.Env = "Production" }, "", func(r *model.GetOrderIDRequest) { r.Env = "Production" }},
+		{"trimmed", func(r *model.GetOrderIDRequest) {
+			r.BuyerName, r.Description = "  Buyer\t", "\nBook "
+		}, "", func(r *model.GetOrderIDRequest) {}},
+		{"phone separators", func(r *model.GetOrderIDRequest) { r.BuyerPhone = "+91 98765-43210" }, "", func(r *model.GetOrderIDRequest) { r.BuyerPhone = "+919876543210" }},
+		{"unknown env", func(r *model.GetOrderIDRequest) { r.Env = "staging" }, "env: must be test or production", nil},
+		{"missing fields", func(r *model.GetOrderIDRequest) { *r = model.GetOrderIDRequest{} },
+			"amount: must be a number with at most two decimals; buyer_email: is required; buyer_name: is required; buyer_phone: is required; description: is required", nil},
+		{"blank name and description", func(r *model.GetOrderIDRequest) { r.BuyerName, r.Description = "  ", "\t" },
+			"buyer_name: is required; description: is required", nil},
+		{"long name", func(r *model.GetOrderIDRequest) { r.BuyerName = strings.Repeat("n", 101) }, "buyer_name: must be at most 100 characters", nil},
+		{"name of 100 runes", func(r *model.GetOrderIDRequest) { r.BuyerName = strings.Repeat("é", 100) }, "", func(r *model.GetOrderIDRequest) { r.BuyerName = strings.Repeat("é", 100) }},
+		{"long description", func(r *model.GetOrderIDRequest) { r.Description = strings.Repeat("d", 256) }, "description: must be at most 255 characters", nil},
+		{"email with a name", func(r *model.GetOrderIDRequest) { r.BuyerEmail = "Buyer <buyer@example.com>" }, "buyer_email: must be a valid email address", nil},
+		{"invalid email", func(r *model.GetOrderIDRequest) { r.BuyerEmail = "buyer" }, "buyer_email: must be a valid email address", nil},
+		{"landline", func(r *model.GetOrderIDRequest) { r.BuyerPhone = "2212345678" }, "buyer_phone: must be a 10 digit Indian mobile number", nil},
+		{"short phone", func(r *model.GetOrderIDRequest) { r.BuyerPhone = "987654321" }, "buyer_phone: must be a 10 digit Indian mobile number", nil},
+		{"three decimals", func(r *model.GetOrderIDRequest) { r.Amount = "10.505" }, "amount: must be a number with at most two decimals", nil},
+		{"below the minimum", func(r *model.GetOrderIDRequest) { r.Amount = "8.99" }, "amount: must be between 9.00 and 200000.00", nil},
+		{"minimum", func(r *model.GetOrderIDRequest) { r.Amount = "9" }, "", func(r *model.GetOrderIDRequest) { r.Amount = "9" }},
+		{"above the maximum", func(r *model.GetOrderIDRequest) { r.Amount = "200000.01" }, "amount: must be between 9.00 and 200000.00", nil},
+	}
+
+	for _, test := range tests {
+		request := valid
+		test.change(&request)
+
+		sent, err := ValidateOrderRequest(request)
+		if fields := rejected(t, err); fields != test.fields {
+			t.Errorf("%s: ValidateOrderRequest() rejected %q, want %q", test.name, fields, test.fields)
+			continue
+		}
+
+		if test.sent == nil {
+			continue
+		}
+
+		want := valid
+		test.sent(&want)
+		if sent != want {
+			t.Errorf("%s: ValidateOrderRequest() = %+v, want %+v", test.name, sent, want)
+		}
+	}
+}
+
+func TestValidateStatusQuery(t *testing.T) {
+	tests := []struct {
+		name string
+
+		env, orderID, transactionID string
+
+		fields string
+	}{
+		{"order ID", "", "ORDER_1-a", "", ""},
+		{"transaction ID", "production", "", "tx-1", ""},
+		{"both", "test", "ORDER1", "tx-1", ""},
+		{"neither", "test", "", "", "order_id: order_id or transaction_id is required"},
+		{"unsafe order ID", "test", "../refunds", "", "order_id: must be at most 64 letters, digits, '-' or '_'"},
+		{"long transaction ID", "test", "", strings.Repeat("t", 65), "transaction_id: must be at most 64 letters, digits, '-' or '_'"},
+		{"unknown env", "live", "ORDER1", "", "env: must be test or production"},
+	}
+
+	for _, test := range tests {
+		err := ValidateStatusQuery(test.env, test.orderID, test.transactionID)
+		if fields := rejected(t, err); fields != test.fields {
+			t.Errorf("%s: ValidateStatusQuery() rejected %q, want %q", test.name, fields, test.fields)
+		}
+	}
+}
+
+func TestValidateStatusBatch(t *testing.T) {
+	tests := []struct {
+		name string
+
+		request model.StatusBatchRequest
+
+		fields string
+	}{
+		{"within the size", model.StatusBatchRequest{Orders: make([]model.StatusQuery, 2)}, ""},
+		{"empty", model.StatusBatchRequest{}, "orders: is required"},
+		{"too many", model.StatusBatchRequest{Orders: make([]model.StatusQuery, 3)}, "orders: must have at most 2 orders"},
+		{"unknown env", model.StatusBatchRequest{Env: "live", Orders: make([]model.StatusQuery, 1)}, "env: must be test or production"},
+	}
+
+	for _, test := range tests {
+		err := ValidateStatusBatch(test.request, 2)
+		if fields := rejected(t, err); fields != test.fields {
+			t.Errorf("%s: ValidateStatusBatch() rejected %q, want %q", test.name, fields, test.fields)
+		}
+	}
+}
+
+func TestValidateRefundRequest(t *testing.T) {
+	tests := []struct {
+		name string
+
+		env, transactionID, amount, refundType string
+
+		fields string
+	}{
+		{"default type", "test", "tx-1", "10", "", ""},
+		{"type", "production", "tx-1", "0.01", "QFL", ""},
+		{"missing transaction ID", "test", "", "10", "", "transaction_id: is required"},
+		{"zero amount", "test", "tx-1", "0", "", "amount: must be a positive number with at most two decimals"},
+		{"unknown type", "test", "tx-1", "10", "XYZ", "type: must be one of RFD, TNR, QFL, QNR, EWN, TAN or PTH"},
+		{"everything", "live", "tx 1", "ten", "XYZ",
+			"amount: must be a positive number with at most two decimals; env: must be test or production; transaction_id: must be at most 64 letters, digits, '-' or '_'; type: must be one of RFD, TNR, QFL, QNR, EWN, TAN or PTH"},
+	}
+
+	for _, test := range tests {
+		err := ValidateRefundRequest(test.env, test.transactionID, test.amount, test.refundType)
+		if fields := rejected(t, err); fields != test.fields {
+			t.Errorf("%s: ValidateRefundRequest() rejected %q, want %q", test.name, fields, test.fields)
+		}
+	}
+}
+
+func TestValidateLookup(t *testing.T) {
+	tests := []struct {
+		name string
+
+		env, id string
+
+		fields string
+	}{
+		{"valid", "test", "C1", ""},
+		{"missing", "test", "", "refund_id: is required"},
+		{"unsafe", "test", "C1?x=1", "refund_id: must be at most 64 letters, digits, '-' or '_'"},
+		{"unknown env", "prod", "C1", "env: must be test or production"},
+	}
+
+	for _, test := range tests {
+		err := ValidateLookup(test.env, "refund_id", test.id)
+		if fields := rejected(t, err); fields != test.fields {
+			t.Errorf("%s: ValidateLookup() rejected %q, want %q", test.name, fields, test.fields)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// Order statuses after which an order no longer changes
+var finalOrderStatuses = map[string]bool{
+	orderCompleted: true,
+	orderFailed:    true,
+	orderExpired:   true,
+}
+
+// IsFinal reports whether the order has reached a status it won't leave
+func IsFinal(order *model.OrderDetails) bool {
+	return finalOrderStatuses[order.Status]
+}
+
+// WatchOrder calls emit with the details of the order, then again every time they change, until the order
+// reaches a final status or ctx is done. Changes are looked for when a webhook for the order arrives, and
+// every interval otherwise. It returns ctx.Err() when ctx is done first, or the error of emit or of a lookup.
+func (c *Client) WatchOrder(ctx context.Context, orderID string, interval time.Duration, emit func(*model.OrderDetails) error) error {
+	changed, unsubscribe := c.shared.changes.subscribe(orderID)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		order, err := c.GetOrderDetails(ctx, orderID, "")
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		current, err := json.Marshal(order)
+		if err != nil {
+			return err
+		}
+
+		if string(current) != string(last) {
+			if err := emit(order); err != nil {
+				return err
+			}
+			last = current
+		}
+
+		if IsFinal(order) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// broker tells the watchers of an order that it may have changed.
+// The zero value is ready to use.
+type broker struct {
+	mu sync.Mutex
+
+	// subscribers by order ID
+	subscribers map[string]map[chan struct{}]bool
+}
+
+// subscribe returns a channel signalled when orderID may have changed, and the function ending the subscription
+func (b *broker) subscribe(orderID string) (<-chan struct{}, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = map[string]map[chan struct{}]bool{}
+	}
+
+	if b.subscribers[orderID] == nil {
+		b.subscribers[orderID] = map[chan struct{}]bool{}
+	}
+
+	// Buffered so a change while the watcher is busy isn't lost
+	changed := make(chan struct{}, 1)
+	b.subscribers[orderID][changed] = true
+
+	return changed, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers[orderID], changed)
+		if len(b.subscribers[orderID]) == 0 {
+			delete(b.subscribers, orderID)
+		}
+	}
+}
+
+// publish signals the subscribers of orderID
+func (b *broker) publish(orderID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for changed := range b.subscribers[orderID] {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
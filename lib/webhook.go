@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+// Webhook payment statuses
+const (
+	webhookCredit = "Credit"
+	webhookFailed = "Failed"
+)
+
+// Payment and order statuses of the gateway API
+const (
+	paymentSuccessful = "successful"
+	paymentFailed     = "failed"
+	orderCompleted    = "completed"
+	orderFailed       = "failed"
+	orderExpired      = "expired"
+)
+
+// ErrDuplicateWebhook is returned for a payment notification that was already received
+var ErrDuplicateWebhook = errors.New("Payment notification already received")
+
+// ParseWebhook reads a payment notification from its form encoded body
+func ParseWebhook(body []byte) (*model.Notification, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Notification{
+		PaymentID:        values.Get("payment_id"),
+		PaymentRequestID: values.Get("payment_request_id"),
+		Status:           values.Get("status"),
+		Amount:           values.Get("amount"),
+		Fees:             values.Get("fees"),
+		Currency:         values.Get("currency"),
+		Raw:              string(body),
+		ReceivedAt:       time.Now(),
+	}, nil
+}
+
+// SaveUnverifiedWebhook keeps a notification whose MAC no environment could verify, for later investigation
+func SaveUnverifiedWebhook(orderStore store.OrderStore, notification *model.Notification) error {
+	notification.Verified = false
+	return orderStore.SaveNotification(notification)
+}
+
+// VerifyWebhook reports whether the notification was signed with the salt of the client's environment
+func (c *Client) VerifyWebhook(notification *model.Notification) bool {
+	if c.salt == "" {
+		return false
+	}
+
+	values, err := url.ParseQuery(notification.Raw)
+	if err != nil {
+		return false
+	}
+
+	mac, err := hex.DecodeString(values.Get("mac"))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(mac, webhookMAC(values, c.salt))
+}
+
+// ReceiveWebhook keeps a verified notification and applies it to the stored order.
+// It returns ErrDuplicateWebhook if the same payment status was already applied.
+func (c *Client) ReceiveWebhook(notification *model.Notification) error {
+	notification.MerchantID = c.merchantID
+	notification.Env = c.env
+	notification.Verified = true
+
+	delivery := notification.PaymentID + ":" + notification.Status
+	duplicate, err := c.store.Delivered(delivery)
+	if err != nil {
+		return err
+	}
+
+	notification.Duplicate = duplicate
+	if err := c.store.SaveNotification(notification); err != nil {
+		return err
+	}
+
+	if duplicate {
+		return ErrDuplicateWebhook
+	}
+
+	if err := c.applyWebhook(notification); err != nil {
+		return err
+	}
+
+	// Recorded only once applied, so a notification failing half way is applied again when Instamojo redelivers it
+	_, err = c.store.RecordDelivery(delivery)
+	return err
+}
+
+// applyWebhook applies the payment status of a notification to its order
+func (c *Client) applyWebhook(notification *model.Notification) error {
+	order, err := c.webhookOrder(notification.PaymentRequestID)
+	if err == store.ErrNotFound {
+		log.Printf("Payment %s is for unknown payment request %s", notification.PaymentID, notification.PaymentRequestID)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The salt that signed the notification only vouches for the orders of its own merchant and environment.
+	// Orders saved before merchants were recorded belong to the only merchant there was.
+	if order.Env != c.env || (order.MerchantID != "" && order.MerchantID != c.merchantID) {
+		log.Printf("Payment %s from %s %s is for order %s of %s %s, ignoring it",
+			notification.PaymentID, c.merchantID, c.env, order.OrderID, order.MerchantID, order.Env)
+		return nil
+	}
+
+	// Watchers know the order by its order ID. They are told once the notification is applied.
+	defer c.shared.changes.publish(order.OrderID)
+
+	payment := model.Payment{ID: notification.PaymentID}
+	for _, existing := range order.Payments {
+		if existing.ID == payment.ID {
+			payment = existing
+		}
+	}
+
+	switch notification.Status {
+	case webhookCredit:
+		payment.Status = paymentSuccessful
+	case webhookFailed:
+		payment.Status = paymentFailed
+	default:
+		log.Printf("Unknown status %s for payment %s", notification.Status, notification.PaymentID)
+		return nil
+	}
+
+	if err := c.store.SavePayment(order.TransactionID, payment); err != nil {
+		return err
+	}
+
+	log.Printf("Payment %s of order %s is %s", payment.ID, order.OrderID, payment.Status)
+	if payment.Status != paymentSuccessful {
+		return nil
+	}
+
+	order, err = c.store.OrderByTransactionID(order.TransactionID)
+	if err != nil {
+		return err
+	}
+
+	order.Status = orderCompleted
+	order.UpdatedAt = time.Now()
+	return c.store.SaveOrder(order)
+}
+
+// webhookOrder returns the order of the gateway order paymentRequestID. Orders saved without
+// a payment request ID, by earlier versions of the server, are looked up by order ID instead.
+func (c *Client) webhookOrder(paymentRequestID string) (*model.OrderRecord, error) {
+	order, err := c.store.OrderByPaymentRequestID(paymentRequestID)
+	if err == store.ErrNotFound {
+		return c.store.OrderByOrderID(paymentRequestID)
+	}
+
+	return order, err
+}
+
+// webhookMAC signs the notification values like Instamojo does: the values, ordered by their
+// case-insensitive key and joined with "|", are signed with HMAC-SHA1 using the salt
+func webhookMAC(values url.Values, salt string) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if key != "mac" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.ToLower(keys[i]) < strings.ToLower(keys[j])
+	})
+
+	message := make([]string, 0, len(keys))
+	for _, key := range keys {
+		message = append(message, values.Get(key))
+	}
+
+	mac := hmac.New(sha1.New, []byte(salt))
+	mac.Write([]byte(strings.Join(message, "|")))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+// sampleWebhook is a payment notification as Instamojo sends it, signed with the salt test-salt
+const sampleWebhook = "amount=2500.00&buyer=nandana%40example.com&buyer_name=Nandana&buyer_phone=%2B919999999999" +
+	"&currency=INR&fees=125.00&longurl=https%3A%2F%2Fwww.instamojo.com%2F%40ashwch%2Fd66cb29dd059482e8072999f995c4eef" +
+	"&mac=6381152aed46022231ca4d3d5d7b188344085215&payment_id=MOJO5a06005J21512197" +
+	"&payment_request_id=d66cb29dd059482e8072999f995c4eef&purpose=Fifa+16&shorturl=https%3A%2F%2Fimjo.in%2FNNxHg&status=Credit"
+
+func TestWebhookMAC(t *testing.T) {
+	tests := []struct {
+		body string
+
+		salt string
+
+		mac string
+	}{
+		{sampleWebhook, "test-salt", "6381152aed46022231ca4d3d5d7b188344085215"},
+		// Keys are ordered regardless of their case
+		{"payment_id=MOJO1&Status=Credit&amount=10.00", "test-salt", "51875f647421da6dd4ea8597c35635a9539530e2"},
+	}
+
+	for _, test := range tests {
+		values, err := url.ParseQuery(test.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mac := hex.EncodeToString(webhookMAC(values, test.salt)); mac != test.mac {
+			t.Errorf("webhookMAC(%q) = %s, want %s", test.body, mac, test.mac)
+		}
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	tests := []struct {
+		name string
+
+		body string
+
+		salt string
+
+		verified bool
+	}{
+		{"signed", sampleWebhook, "test-salt", true},
+		{"other salt", sampleWebhook, "production-salt", false},
+		{"no salt", sampleWebhook, "", false},
+		{"altered", strings.Replace(sampleWebhook, "amount=2500.00", "amount=25.00", 1), "test-salt", false},
+		{"unsigned", "payment_id=MOJO1&status=Credit", "test-salt", false},
+	}
+
+	for _, test := range tests {
+		c := NewClient(ClientConfig{Salt: test.salt})
+		notification, err := ParseWebhook([]byte(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if verified := c.VerifyWebhook(notification); verified != test.verified {
+			t.Errorf("%s: VerifyWebhook() = %v, want %v", test.name, verified, test.verified)
+		}
+	}
+}
+
+func TestReceiveWebhook(t *testing.T) {
+	orders := store.NewMemoryStore()
+	c := NewClient(ClientConfig{MerchantID: "shop", Env: TestENV, Store: orders})
+
+	err := orders.SaveOrder(&model.OrderRecord{
+		TransactionID:    "order-1",
+		OrderID:          "ORDER1",
+		PaymentRequestID: "d66cb29dd059482e8072999f995c4eef",
+		MerchantID:       "shop",
+		Env:              TestENV,
+		Status:           "pending",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, unsubscribe := c.shared.changes.subscribe("ORDER1")
+	defer unsubscribe()
+
+	for i, want := range []error{nil, ErrDuplicateWebhook} {
+		notification, err := ParseWebhook([]byte(sampleWebhook))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.ReceiveWebhook(notification); err != want {
+			t.Errorf("delivery %d: ReceiveWebhook() error %v, want %v", i+1, err, want)
+		}
+	}
+
+	order, err := orders.OrderByTransactionID("order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != orderCompleted || len(order.Payments) != 1 || order.Payments[0].Status != paymentSuccessful {
+		t.Errorf("order %+v, want it completed by payment MOJO5a06005J21512197", order)
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Error("watchers of the order weren't told of the payment")
+	}
+}
+
+func TestReceiveWebhookForOtherOrders(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// merchant and env of the order, the notification being signed for shop in test
+		merchant, env string
+
+		status string
+	}{
+		{"same merchant and env", "shop", TestENV, orderCompleted},
+		{"other env", "shop", ProdENV, "pending"},
+		{"other merchant", "other-shop", TestENV, "pending"},
+		{"saved before merchants", "", TestENV, orderCompleted},
+		{"saved before merchants, other env", "", ProdENV, "pending"},
+	}
+
+	for _, test := range tests {
+		orders := store.NewMemoryStore()
+		c := NewClient(ClientConfig{MerchantID: "shop", Env: TestENV, Store: orders})
+
+		err := orders.SaveOrder(&model.OrderRecord{
+			TransactionID:    "order-1",
+			OrderID:          "ORDER1",
+			PaymentRequestID: "d66cb29dd059482e8072999f995c4eef",
+			MerchantID:       test.merchant,
+			Env:              test.env,
+			Status:           "pending",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		notification, err := ParseWebhook([]byte(sampleWebhook))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.ReceiveWebhook(notification); err != nil {
+			t.Errorf("%s: ReceiveWebhook() error %v", test.name, err)
+		}
+
+		order, err := orders.OrderByTransactionID("order-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if order.Status != test.status {
+			t.Errorf("%s: order is %s, want %s", test.name, order.Status, test.status)
+		}
+	}
+}
@@ -4,8 +4,25 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+// RequestIDHandler gives every request an ID, sent back in the X-Request-ID header.
+// An ID sent by the caller is kept so requests can be traced across services.
+func RequestIDHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = uuid.New().String()
+			r.Header.Set(requestIDHeader, requestID)
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+		handler.ServeHTTP(w, r)
+	})
+}
+
 //LoggingHandler wraps the handler with logger
 func LoggingHandler(handler http.Handler) http.Handler {
 	return loggingHandler{handler}
@@ -21,10 +38,10 @@ func (l loggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	l.handler.ServeHTTP(writer, r)
 	end := time.Now()
 	latency := end.Sub(start)
-	fmt.Printf("%s [%v] \"%s %s %s\" %d %d \"%s\" %v\n",
+	fmt.Printf("%s [%v] \"%s %s %s\" %d %d \"%s\" %v %s\n",
 		r.RemoteAddr, end.Format(time.RFC1123Z),
 		r.Method, r.URL.Path, r.Proto,
-		writer.status, writer.size, r.Header.Get("User-Agent"), latency)
+		writer.status, writer.size, r.Header.Get("User-Agent"), latency, r.Header.Get(requestIDHeader))
 }
 
 type responseWriter struct {
@@ -50,3 +67,10 @@ func (w *responseWriter) WriteHeader(header int) {
 	w.W.WriteHeader(header)
 	w.status = header
 }
+
+// Flush sends the data written so far to the client, for streamed responses
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.W.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -1,73 +1,302 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/Instamojo/sample-sdk-server/lib"
 	"github.com/gorilla/mux"
+	"github.com/instamojo/sample-sdk-server/auth"
+	"github.com/instamojo/sample-sdk-server/config"
+	"github.com/instamojo/sample-sdk-server/idempotency"
+	"github.com/instamojo/sample-sdk-server/lib"
 	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+// orderStore is shared by the clients of every environment
+var orderStore store.OrderStore
+
+// shared is the state of the clients that is kept when they are replaced on reload
+var shared = lib.NewShared()
+
+// maxWebhookSize bounds the body of a payment notification
+const maxWebhookSize = 64 << 10
+
+// shuttingDown is closed once the server starts shutting down, which ends the status streams
+var shuttingDown = make(chan struct{})
+
+// idempotentOrders has the orders created with an Idempotency-Key
+var idempotentOrders *idempotency.Cache
+
+// Headers of idempotent order creation
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeySize    = 255
 )
 
 func main() {
 	log.SetFlags(log.Lshortfile)
 
-	router := mux.NewRouter()
-	router.HandleFunc("/order", createOrder).Methods("POST")
-	router.HandleFunc("/status", statusHandler).Methods("GET")
-	router.HandleFunc("/refund", refundHandler).Methods("POST")
-	router.HandleFunc("/ping", pingHandler).Methods("GET")
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+
+	if err != nil {
+		log.Fatalf("Loading the configuration failed. Error : %s", err)
+	}
+
+	orderStore, err = store.New(cfg.Store, cfg.StorePath)
+	if err != nil {
+		log.Fatalf("Opening the order store failed. Error : %s", err)
+	}
+	defer orderStore.Close()
+
+	if cfg.AuthKeys == "" {
+		log.Println("No -auth-keys file, requests are not authenticated")
+	}
+
+	idempotentOrders = idempotency.NewCache(cfg.IdempotencyTTL)
+
+	s, err := newSettings(cfg, nil)
+	if err != nil {
+		log.Fatalf("Loading the configuration failed. Error : %s", err)
+	}
+	current.Store(s)
+	go reloadOnChange(os.Args[1:], cfg.ReloadInterval)
+
+	serverAddr := fmt.Sprintf(":%s", cfg.Port)
+	server := &http.Server{
+		Addr:         serverAddr,
+		Handler:      LoggingHandler(RequestIDHandler(http.HandlerFunc(settingsHandler))),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	// Shutdown doesn't cancel the requests in flight, so a stream would otherwise hold it up to -stream-timeout
+	server.RegisterOnShutdown(endStreams)
+
+	stopped := make(chan struct{})
+	go shutdownOnSignal(server, cfg.ShutdownGrace, stopped)
+
+	fmt.Printf("Starting server on port %s\n", cfg.Port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	<-stopped
+	log.Println("Server stopped")
+}
+
+// shutdownOnSignal stops server on SIGTERM or SIGINT. Requests in flight get
+// up to grace to finish before their connections are closed.
+func shutdownOnSignal(server *http.Server, grace time.Duration, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	received := <-signals
+	signal.Stop(signals)
+
+	log.Printf("Received %v, draining requests for up to %v", received, grace)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Requests still in flight after %v. Error %v", grace, err)
+		server.Close()
+	}
+}
+
+// errEnvNotConfigured is returned when the merchant of the caller has no credentials for the environment
+var errEnvNotConfigured = errors.New("The merchant has no credentials for this environment")
+
+// newClients returns a client for each environment of each merchant of cfg
+func newClients(cfg *config.Config) map[string]map[string]*lib.Client {
+	retry := lib.RetryPolicy{
+		MaxRetries: cfg.MaxRetries,
+		BaseDelay:  cfg.RetryBaseDelay,
+		MaxDelay:   cfg.RetryMaxDelay,
+	}
+
+	timeouts := lib.Timeouts{
+		Token:  cfg.TokenTimeout,
+		Order:  cfg.OrderTimeout,
+		Status: cfg.StatusTimeout,
+		Refund: cfg.RefundTimeout,
+	}
+
+	merchantClients := map[string]map[string]*lib.Client{}
+	for _, merchant := range cfg.Registry() {
+		envs := map[string]*lib.Client{}
+		for env, credentials := range map[string]*config.Credentials{lib.ProdENV: merchant.Production, lib.TestENV: merchant.Test} {
+			if credentials == nil {
+				continue
+			}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+			envs[env] = lib.NewClient(lib.ClientConfig{
+				Env:          env,
+				MerchantID:   merchant.ID,
+				BaseURL:      credentials.URL,
+				ClientID:     credentials.ClientID,
+				ClientSecret: credentials.ClientSecret,
+				Salt:         credentials.Salt,
+				RedirectURL:  merchant.RedirectURL,
+				Currency:     merchant.Currency,
+				Retry:        retry,
+				Timeouts:     timeouts,
+				Store:        orderStore,
+				Shared:       shared,
+			})
+		}
+		merchantClients[merchant.ID] = envs
 	}
 
-	serverAddr := fmt.Sprintf(":%s", port)
-	fmt.Printf("Starting server on port %s\n", port)
-	log.Fatal(http.ListenAndServe(serverAddr, LoggingHandler(router)))
+	return merchantClients
+}
+
+// checkKeyMerchants checks every key acts for a merchant of the registry
+func checkKeyMerchants(keys []auth.Key, registry []config.Merchant) error {
+	known := map[string]bool{}
+	for _, merchant := range registry {
+		known[merchant.ID] = true
+	}
+
+	for _, key := range keys {
+		if !known[merchantOf(key.Merchant)] {
+			return fmt.Errorf("key %s acts for unknown merchant %s", key.ID, merchantOf(key.Merchant))
+		}
+	}
+
+	return nil
+}
+
+// merchantOf returns the merchant ID of a key or principal, the default merchant when empty
+func merchantOf(merchantID string) string {
+	if merchantID == "" {
+		return config.DefaultMerchant
+	}
+
+	return merchantID
+}
+
+// clientFor returns the client for env of the merchant the caller acts for. Anything
+// other than production uses test, so env must be validated first.
+func clientFor(r *http.Request, env string) (*lib.Client, error) {
+	merchantID := config.DefaultMerchant
+	if principal := principalOf(r); principal != nil {
+		merchantID = merchantOf(principal.MerchantID)
+	}
+
+	env = strings.ToLower(env)
+	if env != lib.ProdENV {
+		env = lib.TestENV
+	}
+
+	client, ok := settingsOf(r).clients[merchantID][env]
+	if !ok {
+		return nil, errEnvNotConfigured
+	}
+
+	return client, nil
 }
 
 func createOrder(w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
 		log.Println("no body")
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Request body is missing")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Order read error %v", err)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Request body could not be read")
 		return
 	}
 
 	var getOrderIDRequest model.GetOrderIDRequest
-	goErr := json.NewDecoder(r.Body).Decode(&getOrderIDRequest)
+	goErr := json.Unmarshal(body, &getOrderIDRequest)
 	if goErr != nil {
 		log.Printf("decoder error %v", goErr)
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Request body is not valid JSON")
 		return
 	}
 
-	createdOrder, err := lib.CreateOrder(getOrderIDRequest)
+	getOrderIDRequest, err = lib.ValidateOrderRequest(getOrderIDRequest)
 	if err != nil {
-		log.Fatalf("Order creation failed. Error : %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Println(err)
+		writeError(w, err)
 		return
 	}
 
-	log.Printf("Created order: %+v", createdOrder)
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeySize {
+		writeError(w, &lib.ValidationError{Fields: map[string][]string{idempotencyKeyHeader: {"must be at most 255 characters"}}})
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	bytes, err := json.Marshal(createdOrder)
+	client, err := clientFor(r, getOrderIDRequest.Env)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
+	if idempotencyKey == "" {
+		createdOrder, err := client.CreateOrder(r.Context(), getOrderIDRequest)
+		if err != nil {
+			log.Printf("Order creation failed. Error : %s", err)
+			writeError(w, err)
+			return
+		}
 
-	w.Write(bytes)
+		log.Printf("Created order: %+v", createdOrder)
+		writeJSON(w, http.StatusOK, createdOrder)
+		return
+	}
+
+	// Keys are only unique per caller
+	if principal := principalOf(r); principal != nil {
+		idempotencyKey = principal.KeyID + ":" + idempotencyKey
+	}
+
+	result, replayed, err := idempotentOrders.Do(idempotencyKey, body, func(transactionID *string) (interface{}, error) {
+		// Not bound to the request, which is cancelled when the caller's network drops.
+		// The order is still created so the caller's retry gets it.
+		// A retry after a failure finishes the order of the same transaction ID.
+		return client.CreateOrderOnce(context.Background(), getOrderIDRequest, transactionID)
+	})
+	if err != nil {
+		log.Printf("Order creation failed. Error : %s", err)
+		writeError(w, err)
+		return
+	}
+
+	if replayed {
+		w.Header().Set(idempotentReplayedHeader, "true")
+		log.Printf("Replayed order: %+v", result)
+	} else {
+		log.Printf("Created order: %+v", result)
+	}
+	writeJSON(w, http.StatusOK, result)
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query parameters")
 		return
 	}
 
@@ -75,39 +304,343 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	orderID := r.FormValue("order_id")
 	transactionID := r.FormValue("transaction_id")
 
-	gatewayOrderStatus, err := lib.GetOrderStatus(env, orderID, transactionID)
+	if err := lib.ValidateStatusQuery(env, orderID, transactionID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	client, err := clientFor(r, env)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The unversioned path keeps replying with the compact form its callers were written for
+	compact := !strings.HasPrefix(r.URL.Path, apiVersion+"/")
+	if value := r.FormValue("compact"); value != "" {
+		if compact, err = strconv.ParseBool(value); err != nil {
+			writeError(w, &lib.ValidationError{Fields: map[string][]string{"compact": {"must be true or false"}}})
+			return
+		}
+	}
+
+	if compact {
+		gatewayOrderStatus, err := client.GetOrderStatus(r.Context(), orderID, transactionID)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, gatewayOrderStatus)
+		return
+	}
+
+	orderDetails, err := client.GetOrderDetails(r.Context(), orderID, transactionID)
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, orderDetails)
+}
+
+func statusBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Request body is missing")
+		return
+	}
+
+	var request model.StatusBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("decoder error %v", err)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Request body is not valid JSON")
+		return
+	}
+
+	cfg := settingsOf(r).cfg
+	if err := lib.ValidateStatusBatch(request, cfg.MaxBatchSize); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	bytes, err := json.Marshal(gatewayOrderStatus)
+	client, err := clientFor(r, request.Env)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	w.Write(bytes)
+	results, err := client.GetOrderDetailsBatch(r.Context(), request.Orders, cfg.BatchParallelism)
+	if err != nil {
+		log.Println(err)
+		writeError(w, err)
+		return
+	}
+
+	response := model.StatusBatchResponse{Results: make([]model.StatusBatchResult, len(results))}
+	for i, result := range results {
+		response.Results[i] = model.StatusBatchResult{StatusQuery: request.Orders[i], Order: result.Order}
+		if result.Err != nil {
+			errorBody := errorFor(result.Err).body
+			response.Results[i].Error = &errorBody
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func statusStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query parameters")
+		return
+	}
+
+	env := r.FormValue("env")
+	orderID := r.FormValue("order_id")
+
+	if err := lib.ValidateLookup(env, "order_id", orderID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	client, err := clientFor(r, env)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrorCode(w, http.StatusInternalServerError, codeInternalError, "Streaming is not supported")
+		return
+	}
+
+	cfg := settingsOf(r).cfg
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.StreamTimeout)
+	defer cancel()
+
+	ending := shuttingDown
+	go func() {
+		select {
+		case <-ending:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// The stream only starts with the first status, so a failed first lookup gets an ordinary error reply
+	started := false
+	err = client.WatchOrder(ctx, orderID, cfg.StreamPollInterval, func(order *model.OrderDetails) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		if err := writeEvent(w, "status", order); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+
+	if !started {
+		log.Println(err)
+		writeError(w, err)
+		return
+	}
+
+	switch {
+	case err == nil:
+		writeEvent(w, "end", struct{}{})
+	case ctx.Err() == context.DeadlineExceeded:
+		writeEvent(w, "timeout", struct{}{})
+	case isClosed(ending):
+		writeEvent(w, "shutdown", struct{}{})
+	case r.Context().Err() != nil:
+		// The client went away
+		return
+	default:
+		log.Printf("Streaming order %s failed. Error %v", orderID, err)
+		writeEvent(w, "error", errorFor(err).body)
+	}
+	flusher.Flush()
+}
+
+// endStreams ends the open status streams with a shutdown event
+func endStreams() {
+	close(shuttingDown)
+}
+
+// isClosed reports whether c is closed
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeEvent writes a Server-Sent Event with v as JSON data
+func writeEvent(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
 }
 
 func refundHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Invalid form parameters")
+		return
+	}
+
+	request := model.RefundRequest{
+		Env:           r.FormValue("env"),
+		TransactionID: r.FormValue("transaction_id"),
+		Amount:        r.FormValue("amount"),
+		Type:          strings.ToUpper(r.FormValue("type")),
+		Reason:        r.FormValue("reason"),
+	}
+
+	if err := lib.ValidateRefundRequest(request.Env, request.TransactionID, request.Amount, request.Type); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if principal := principalOf(r); principal != nil {
+		log.Printf("Refund of transaction %s requested with key %s", request.TransactionID, principal.KeyID)
+	}
+
+	client, err := clientFor(r, request.Env)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	refund, err := client.InitiateRefund(r.Context(), request.TransactionID, request.Amount, request.Type, request.Reason)
+	if err != nil {
+		log.Println(err)
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, refund)
+}
+
+func refundStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query parameters")
+		return
+	}
+
+	env := r.FormValue("env")
+	refundID := mux.Vars(r)["id"]
+
+	if err := lib.ValidateLookup(env, "id", refundID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	client, err := clientFor(r, env)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	refund, err := client.GetRefund(r.Context(), refundID)
+	if err != nil {
+		log.Println(err)
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, refund)
+}
+
+func refundLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query parameters")
 		return
 	}
 
 	env := r.FormValue("env")
 	transactionID := r.FormValue("transaction_id")
-	amount := r.FormValue("amount")
 
-	statusCode, err := lib.InitiateRefund(env, transactionID, amount)
+	if err := lib.ValidateLookup(env, "transaction_id", transactionID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	client, err := clientFor(r, env)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ledger, err := client.RefundLedger(r.Context(), transactionID)
 	if err != nil {
 		log.Println(err)
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ledger)
+}
+
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		log.Printf("Webhook read error %v", err)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Request body could not be read")
+		return
+	}
+
+	notification, err := lib.ParseWebhook(body)
+	if err != nil {
+		log.Printf("Webhook parse error %v", err)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "Request body is not form encoded")
+		return
 	}
 
-	w.WriteHeader(statusCode)
+	// The MAC tells which merchant and environment the notification is from
+	for _, envs := range settingsOf(r).clients {
+		for _, client := range envs {
+			if !client.VerifyWebhook(notification) {
+				continue
+			}
+
+			err := client.ReceiveWebhook(notification)
+			if err == lib.ErrDuplicateWebhook {
+				// Acknowledged, so Instamojo stops sending it again
+				log.Printf("Webhook for payment %s was already applied", notification.PaymentID)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if err != nil {
+				log.Printf("Webhook for payment %s failed. Error %v", notification.PaymentID, err)
+				writeError(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	log.Printf("Webhook for payment %s has an invalid MAC", notification.PaymentID)
+	if err := lib.SaveUnverifiedWebhook(orderStore, notification); err != nil {
+		log.Printf("Error saving webhook %v", err)
+	}
+	writeErrorCode(w, http.StatusForbidden, codeInvalidWebhookMAC, "The MAC doesn't match")
 }
 
 func pingHandler(w http.ResponseWriter, r *http.Request) {
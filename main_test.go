@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/config"
+	"github.com/instamojo/sample-sdk-server/idempotency"
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/store"
+)
+
+// fakeInstamojo is the part of the Instamojo API the server is tested against
+type fakeInstamojo struct {
+	mu sync.Mutex
+
+	// tokens counts the access tokens granted, by client ID
+	tokens map[string]int
+
+	// orders are the gateway orders created, by ID
+	orders map[string]model.GatewayOrder
+}
+
+func newFakeInstamojo() *fakeInstamojo {
+	return &fakeInstamojo{tokens: map[string]int{}, orders: map[string]model.GatewayOrder{}}
+}
+
+func (f *fakeInstamojo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const orderPrefix = "/v2/gateway/orders/id:"
+	switch {
+	case r.URL.Path == "/oauth2/token/":
+		clientID := r.FormValue("client_id")
+		f.tokens[clientID]++
+		writeJSON(w, http.StatusOK, model.OAuth2Token{AccessToken: fmt.Sprintf("%s-%d", clientID, f.tokens[clientID]), ExpiresIn: 36000})
+	case r.Method == "POST" && r.URL.Path == "/v2/gateway/orders/":
+		var order model.GatewayOrder
+		json.NewDecoder(r.Body).Decode(&order)
+		order.ID = fmt.Sprintf("GW%d", len(f.orders)+1)
+		order.Status = "pending"
+		f.orders[order.ID] = order
+		writeJSON(w, http.StatusOK, model.GatewayOrderResponse{Order: order})
+	case r.Method == "POST" && r.URL.Path == "/v2/gateway/orders/payment-request/":
+		var request model.OrderRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		order := f.orders[request.PaymentRequestID]
+		writeJSON(w, http.StatusOK, model.Order{OrderID: "ORDER-" + order.ID, Name: order.Name, Email: order.Email, Phone: order.Phone, Amount: order.Amount})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, orderPrefix):
+		// Every order looked up was paid on the second attempt
+		writeJSON(w, http.StatusOK, model.GatewayOrder{
+			ID:            strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, orderPrefix), "/"),
+			TransactionID: "order-1",
+			Amount:        "10.00",
+			Status:        "completed",
+			Payments:      []model.Payment{{ID: "MOJO2", Status: "successful"}, {ID: "MOJO1", Status: "failed"}},
+		})
+	default:
+		writeErrorCode(w, http.StatusNotFound, "not_found", "Not found")
+	}
+}
+
+// tokensOf returns the number of access tokens granted to clientID
+func (f *fakeInstamojo) tokensOf(clientID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tokens[clientID]
+}
+
+// testServer is the server with its settings, talking to a fakeInstamojo
+type testServer struct {
+	*httptest.Server
+
+	upstream *httptest.Server
+}
+
+// testArgs are the settings the server needs to start, talking to the Instamojo API at url
+func testArgs(url string) []string {
+	return []string{
+		"-production-client-id", "prod-id", "-production-client-secret", "prod-secret",
+		"-test-client-id", "test-id", "-test-client-secret", "test-secret",
+		"-production-url", url, "-test-url", url,
+	}
+}
+
+// startTestServer starts the server with the settings of args on an empty memory store
+func startTestServer(t *testing.T, instamojo http.Handler, args ...string) *testServer {
+	upstream := httptest.NewServer(instamojo)
+
+	cfg, err := config.Load(append(testArgs(upstream.URL), args...))
+	if err != nil {
+		upstream.Close()
+		t.Fatalf("config.Load() error %v", err)
+	}
+
+	orderStore = store.NewMemoryStore()
+	idempotentOrders = idempotency.NewCache(cfg.IdempotencyTTL)
+	s, err := newSettings(cfg, nil)
+	if err != nil {
+		upstream.Close()
+		t.Fatalf("newSettings() error %v", err)
+	}
+	current.Store(s)
+
+	return &testServer{Server: httptest.NewServer(http.HandlerFunc(settingsHandler)), upstream: upstream}
+}
+
+func (s *testServer) Close() {
+	s.Server.Close()
+	s.upstream.Close()
+}
+
+// do sends a request to the server with headers, given as name and value pairs
+func (s *testServer) do(t *testing.T, method, path, body string, headers ...string) *http.Response {
+	request, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// writeTestFile writes content to a temporary file and returns its path
+func writeTestFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "sdk-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// decodeBody decodes the JSON body of response into v and closes it
+func decodeBody(t *testing.T, response *http.Response, v interface{}) {
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		t.Fatalf("decoding the body of %s: %v", response.Request.URL, err)
+	}
+}
+
+func TestCreateOrderIdempotency(t *testing.T) {
+	instamojo := newFakeInstamojo()
+	server := startTestServer(t, instamojo)
+	defer server.Close()
+
+	order := `{"env": "test", "buyer_name": "Buyer", "buyer_email": "buyer@example.com", "buyer_phone": "9999999999", "amount": "10", "description": "Book"}`
+	other := strings.Replace(order, `"10"`, `"20"`, 1)
+
+	tests := []struct {
+		name string
+
+		key, body string
+
+		status int
+
+		replayed bool
+
+		// orderID or code of the error expected
+		orderID, code string
+	}{
+		{"first", "k1", order, http.StatusOK, false, "ORDER-GW1", ""},
+		{"same key and body", "k1", order, http.StatusOK, true, "ORDER-GW1", ""},
+		{"same key, other body", "k1", other, http.StatusConflict, false, "", codeIdempotencyKeyReused},
+		{"other key", "k2", order, http.StatusOK, false, "ORDER-GW2", ""},
+		{"no key", "", order, http.StatusOK, false, "ORDER-GW3", ""},
+	}
+
+	for _, test := range tests {
+		response := server.do(t, "POST", "/v1/order", test.body, idempotencyKeyHeader, test.key)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+
+		if replayed := response.Header.Get(idempotentReplayedHeader) == "true"; replayed != test.replayed {
+			t.Errorf("%s: replayed %v, want %v", test.name, replayed, test.replayed)
+		}
+
+		var reply struct {
+			model.Order
+
+			model.ErrorResponse
+		}
+		decodeBody(t, response, &reply)
+		if reply.OrderID != test.orderID || reply.Code != test.code {
+			t.Errorf("%s: order %q, error %q, want %q, %q", test.name, reply.OrderID, reply.Code, test.orderID, test.code)
+		}
+	}
+}
+
+func TestStreamEndsOnShutdown(t *testing.T) {
+	instamojo := newFakeInstamojo()
+	pending := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v2/gateway/orders/id:") {
+			writeJSON(w, http.StatusOK, model.GatewayOrder{ID: "GW1", TransactionID: "order-1", Amount: "10.00", Status: "pending"})
+			return
+		}
+		instamojo.ServeHTTP(w, r)
+	})
+
+	server := startTestServer(t, pending)
+	defer server.Close()
+	defer func() { shuttingDown = make(chan struct{}) }()
+	server.Config.RegisterOnShutdown(endStreams)
+
+	response := server.do(t, "GET", "/v1/status/stream?env=test&order_id=GW1", "")
+	defer response.Body.Close()
+
+	events := bufio.NewScanner(response.Body)
+	if !events.Scan() || events.Text() != "event: status" {
+		t.Fatalf("first line %q, want the status event", events.Text())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error %v, want the stream to end", err)
+	}
+
+	ended := false
+	for events.Scan() {
+		ended = ended || events.Text() == "event: shutdown"
+	}
+	if !ended {
+		t.Error("the stream ended without a shutdown event")
+	}
+}
+
+func TestStatusForm(t *testing.T) {
+	server := startTestServer(t, newFakeInstamojo())
+	defer server.Close()
+
+	tests := []struct {
+		name string
+
+		path string
+
+		compact bool
+	}{
+		{"versioned", "/v1/status?env=test&order_id=GW1", false},
+		{"versioned compact", "/v1/status?env=test&order_id=GW1&compact=true", true},
+		{"unversioned", "/status?env=test&order_id=GW1", true},
+		{"unversioned full", "/status?env=test&order_id=GW1&compact=false", false},
+	}
+
+	for _, test := range tests {
+		response := server.do(t, "GET", test.path, "")
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, http.StatusOK)
+		}
+
+		var reply map[string]interface{}
+		decodeBody(t, response, &reply)
+		_, full := reply["payments"]
+		if compact := reply["payment_id"] == "MOJO2"; compact != test.compact || full == test.compact {
+			t.Errorf("%s: reply %v, want compact %v", test.name, reply, test.compact)
+		}
+	}
+}
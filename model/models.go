@@ -1,5 +1,10 @@
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // GetOrderIDRequest is the request from the android app
 // to create and retrieve the Instamojo OrderID
 // OrderID can used to complete the payment with in the app using instamojo-android-sdk
@@ -80,6 +85,20 @@ type Order struct {
 	Amount string `json:"amount"`
 }
 
+// RefundRequest is the form sent to refund a payment of an order
+type RefundRequest struct {
+	Env string `json:"env"`
+
+	TransactionID string `json:"transaction_id"`
+
+	Amount string `json:"amount"`
+
+	// Type is one of the Instamojo refund types, PTH if empty
+	Type string `json:"type"`
+
+	Reason string `json:"reason"`
+}
+
 // GatewayOrderStatus returns the status of the payment order
 type GatewayOrderStatus struct {
 	Amount string `json:"amount"`
@@ -99,5 +118,237 @@ type Payment struct {
 
 	BillingInstrument string `json:"billing_instrument"`
 
-	Failure string `json:"failure"`
+	// Failure is why the payment failed, nil unless it did
+	Failure *PaymentFailure `json:"failure"`
+}
+
+// PaymentFailure is why a payment failed
+type PaymentFailure struct {
+	Reason string `json:"reason"`
+
+	Message string `json:"message"`
+}
+
+// UnmarshalJSON accepts a failure object as well as a bare message
+func (f *PaymentFailure) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		f.Reason, f.Message = "", message
+		return nil
+	}
+
+	type failure PaymentFailure
+	return json.Unmarshal(data, (*failure)(f))
+}
+
+// OrderDetails is the full status of an order, with every payment attempt
+type OrderDetails struct {
+	OrderID string `json:"order_id"`
+
+	TransactionID string `json:"transaction_id"`
+
+	Status string `json:"status"`
+
+	Amount string `json:"amount"`
+
+	Currency string `json:"currency"`
+
+	BuyerName string `json:"buyer_name"`
+
+	BuyerEmail string `json:"buyer_email"`
+
+	BuyerPhone string `json:"buyer_phone"`
+
+	Description string `json:"description"`
+
+	// Payments are the payment attempts, in the order Instamojo lists them
+	Payments []Payment `json:"payments"`
+}
+
+// Refund of a payment
+type Refund struct {
+	ID string `json:"id"`
+
+	PaymentID string `json:"payment_id"`
+
+	TransactionID string `json:"transaction_id"`
+
+	Status string `json:"status"`
+
+	Type string `json:"type"`
+
+	Body string `json:"body"`
+
+	RefundAmount string `json:"refund_amount"`
+
+	TotalAmount string `json:"total_amount"`
+
+	CreatedAt string `json:"created_at"`
+}
+
+// RefundResponse is the response of the create refund call
+type RefundResponse struct {
+	Refund Refund `json:"refund"`
+
+	Success bool `json:"success"`
+}
+
+// RefundList is the response of the list refunds call
+type RefundList struct {
+	Refunds []Refund `json:"refunds"`
+}
+
+// OrderRecord is what the server keeps about an order it created
+type OrderRecord struct {
+	TransactionID string `json:"transaction_id"`
+
+	OrderID string `json:"order_id"`
+
+	// PaymentRequestID is the ID of the gateway order, which payment webhooks refer to
+	PaymentRequestID string `json:"payment_request_id"`
+
+	MerchantID string `json:"merchant_id"`
+
+	Env string `json:"env"`
+
+	BuyerName string `json:"buyer_name"`
+
+	BuyerEmail string `json:"buyer_email"`
+
+	BuyerPhone string `json:"buyer_phone"`
+
+	Amount string `json:"amount"`
+
+	Currency string `json:"currency"`
+
+	Description string `json:"description"`
+
+	Status string `json:"status"`
+
+	Payments []Payment `json:"payments"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Notification is a payment webhook received from Instamojo
+type Notification struct {
+	PaymentID string `json:"payment_id"`
+
+	PaymentRequestID string `json:"payment_request_id"`
+
+	Status string `json:"status"`
+
+	Amount string `json:"amount"`
+
+	Fees string `json:"fees"`
+
+	Currency string `json:"currency"`
+
+	// MerchantID and Env are those of the salt the MAC matched
+	MerchantID string `json:"merchant_id"`
+
+	Env string `json:"env"`
+
+	// Verified is set when the MAC matched the salt of Env
+	Verified bool `json:"verified"`
+
+	// Duplicate is set when the same payment status was delivered before
+	Duplicate bool `json:"duplicate"`
+
+	// Raw is the notification body as it was received
+	Raw string `json:"raw"`
+
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// RefundLedger has the refunds of a payment and their totals
+type RefundLedger struct {
+	TransactionID string `json:"transaction_id"`
+
+	PaymentID string `json:"payment_id"`
+
+	CapturedAmount string `json:"captured_amount"`
+
+	// RequestedAmount is refunded by requests Instamojo hasn't answered yet
+	RequestedAmount string `json:"requested_amount"`
+
+	// PendingAmount is refunded by refunds Instamojo accepted but hasn't completed
+	PendingAmount string `json:"pending_amount"`
+
+	CompletedAmount string `json:"completed_amount"`
+
+	// AvailableAmount can still be refunded
+	AvailableAmount string `json:"available_amount"`
+
+	Refunds []*Refund `json:"refunds"`
+}
+
+// StatusQuery identifies an order by its order ID or its transaction ID
+type StatusQuery struct {
+	OrderID string `json:"order_id,omitempty"`
+
+	TransactionID string `json:"transaction_id,omitempty"`
+}
+
+// StatusBatchRequest asks for the status of several orders of an environment
+type StatusBatchRequest struct {
+	Env string `json:"env"`
+
+	Orders []StatusQuery `json:"orders"`
+}
+
+// StatusBatchResult is the status of one order of a batch, or why it couldn't be found
+type StatusBatchResult struct {
+	StatusQuery
+
+	Order *OrderDetails `json:"order,omitempty"`
+
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// StatusBatchResponse has the results of a batch, in the order of the request
+type StatusBatchResponse struct {
+	Results []StatusBatchResult `json:"results"`
+}
+
+// HealthCheck is the outcome of checking one dependency of the server
+type HealthCheck struct {
+	Name string `json:"name"`
+
+	// Status is ok or failed
+	Status string `json:"status"`
+
+	LatencyMS float64 `json:"latency_ms"`
+
+	// Error is why the check failed
+	Error string `json:"error,omitempty"`
+
+	// LastError is the error of the latest failed check, kept after the check succeeds again
+	LastError string `json:"last_error,omitempty"`
+
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// HealthReport is the reply of the health probes
+type HealthReport struct {
+	// Status is ok when every check is
+	Status string `json:"status"`
+
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// ErrorResponse is the body of every error reply
+type ErrorResponse struct {
+	// Code is machine readable, see the Readme for the list
+	Code string `json:"code"`
+
+	Message string `json:"message"`
+
+	// Fields has the messages for each rejected request field
+	Fields map[string][]string `json:"fields,omitempty"`
+
+	// RequestID identifies the request in the server logs
+	RequestID string `json:"request_id,omitempty"`
 }
@@ -0,0 +1,247 @@
+// Package openapi builds an OpenAPI 3 document describing the server's API.
+// Schemas are generated from Go types through their json tags.
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Version of the OpenAPI specification the documents follow
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI string `json:"openapi"`
+
+	Info Info `json:"info"`
+
+	Paths map[string]PathItem `json:"paths"`
+
+	Components Components `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title string `json:"title"`
+
+	Version string `json:"version"`
+
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem has the operations of a path, by lower case HTTP method
+type PathItem map[string]*Operation
+
+// Operation is an endpoint of the API
+type Operation struct {
+	Summary string `json:"summary,omitempty"`
+
+	Description string `json:"description,omitempty"`
+
+	OperationID string `json:"operationId,omitempty"`
+
+	Deprecated bool `json:"deprecated,omitempty"`
+
+	Parameters []Parameter `json:"parameters,omitempty"`
+
+	RequestBody *RequestBody `json:"requestBody,omitempty"`
+
+	Responses map[string]Response `json:"responses"`
+
+	// Security lists the alternative security requirements, by scheme name
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Name string `json:"name"`
+
+	// In is path, query or header
+	In string `json:"in"`
+
+	Description string `json:"description,omitempty"`
+
+	Required bool `json:"required,omitempty"`
+
+	Schema *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request by content type
+type RequestBody struct {
+	Required bool `json:"required,omitempty"`
+
+	Content map[string]MediaType `json:"content"`
+}
+
+// Response describes a response by content type
+type Response struct {
+	Description string `json:"description"`
+
+	Content map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema describes a value
+type Schema struct {
+	Ref string `json:"$ref,omitempty"`
+
+	Type string `json:"type,omitempty"`
+
+	Format string `json:"format,omitempty"`
+
+	Description string `json:"description,omitempty"`
+
+	Enum []string `json:"enum,omitempty"`
+
+	Properties map[string]*Schema `json:"properties,omitempty"`
+
+	Items *Schema `json:"items,omitempty"`
+
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+}
+
+// Components holds the schemas referenced by the document
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is a way callers authenticate
+type SecurityScheme struct {
+	Type string `json:"type"`
+
+	Description string `json:"description,omitempty"`
+
+	// Name and In locate an apiKey
+	Name string `json:"name,omitempty"`
+
+	In string `json:"in,omitempty"`
+}
+
+// String is the schema of a string
+func String(description string) *Schema {
+	return &Schema{Type: "string", Description: description}
+}
+
+// Builder assembles a Document
+type Builder struct {
+	doc Document
+}
+
+// NewBuilder returns a Builder for a document with no paths
+func NewBuilder(info Info) *Builder {
+	return &Builder{doc: Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      map[string]PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}}
+}
+
+// Add adds the operation for method on path
+func (b *Builder) Add(method, path string, operation *Operation) {
+	item, ok := b.doc.Paths[path]
+	if !ok {
+		item = PathItem{}
+		b.doc.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = operation
+}
+
+// AddSecurityScheme adds a scheme operations can require by name
+func (b *Builder) AddSecurityScheme(name string, scheme SecurityScheme) {
+	if b.doc.Components.SecuritySchemes == nil {
+		b.doc.Components.SecuritySchemes = map[string]SecurityScheme{}
+	}
+	b.doc.Components.SecuritySchemes[name] = scheme
+}
+
+// Document returns the document built so far
+func (b *Builder) Document() *Document {
+	return &b.doc
+}
+
+// SchemaOf returns the schema of the type of v. Named structs are added to the
+// components of the document and referenced from the returned schema.
+func (b *Builder) SchemaOf(v interface{}) *Schema {
+	return b.schema(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *Builder) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.String:
+		return &Schema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &Schema{Type: "number"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := b.doc.Components.Schemas[t.Name()]; !ok {
+			// Registered before its fields, so a type referring to itself doesn't recurse forever
+			b.doc.Components.Schemas[t.Name()] = &Schema{}
+			*b.doc.Components.Schemas[t.Name()] = *b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Struct:
+		return b.structSchema(t)
+	default:
+		return &Schema{}
+	}
+}
+
+func (b *Builder) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			// Like encoding/json, the fields of an embedded struct are promoted
+			for name, property := range b.structSchema(field.Type).Properties {
+				schema.Properties[name] = property
+			}
+			continue
+		}
+
+		name := field.Name
+		if tag != "" {
+			name = strings.Split(tag, ",")[0]
+		}
+
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = b.schema(field.Type)
+	}
+
+	return schema
+}
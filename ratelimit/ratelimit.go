@@ -0,0 +1,179 @@
+// Package ratelimit limits how often each caller may send requests, with token buckets
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit lets Burst requests through at once, then Rate requests per second
+type Limit struct {
+	Rate float64
+
+	Burst int
+}
+
+// Result is the outcome of a request against a Limiter
+type Result struct {
+	Allowed bool
+
+	Limit int
+
+	// Remaining is the number of requests that would be allowed right now
+	Remaining int
+
+	// RetryAfter is how long until the next request is allowed, zero if it would be now
+	RetryAfter time.Duration
+
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter keeps one token bucket per key
+type Limiter struct {
+	limit Limit
+
+	now func() time.Time
+
+	mu sync.Mutex
+
+	buckets map[string]*bucket
+
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+
+	updated time.Time
+}
+
+// New returns a Limiter whose buckets all have limit
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Limit returns the limit of the buckets of l
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token from the bucket of key, if it has one
+func (l *Limiter) Allow(key string) Result {
+	return l.take(key, true)
+}
+
+// Peek returns whether the bucket of key has a token, without taking it
+func (l *Limiter) Peek(key string) Result {
+	return l.take(key, false)
+}
+
+func (l *Limiter) take(key string, consume bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		if consume {
+			l.buckets[key] = b
+		}
+	}
+
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*l.limit.Rate)
+	b.updated = now
+
+	result := Result{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		if consume {
+			b.tokens--
+		}
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.duration(1 - b.tokens)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = l.duration(float64(l.limit.Burst) - b.tokens)
+	return result
+}
+
+// duration returns how long the bucket takes to gain tokens
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// sweep forgets the buckets that have refilled, which behave like new ones
+func (l *Limiter) sweep(now time.Time) {
+	fill := l.duration(float64(l.limit.Burst))
+	if now.Sub(l.lastSweep) < fill {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= fill {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// units of the rates in a limit
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseLimits reads comma separated limits like "default=600/m,createOrder=30/m:10".
+// Each name gets count requests per second, minute or hour, with a burst of count unless one follows the colon.
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, spec := split(entry, "=")
+		spec, burstSpec := split(spec, ":")
+		countSpec, unitSpec := split(spec, "/")
+
+		count, err := strconv.Atoi(countSpec)
+		unit, ok := units[unitSpec]
+		if name == "" || err != nil || count <= 0 || !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected name=count/unit[:burst] with a unit of s, m or h", entry)
+		}
+
+		limit := Limit{Rate: float64(count) / unit.Seconds(), Burst: count}
+		if burstSpec != "" {
+			limit.Burst, err = strconv.Atoi(burstSpec)
+			if err != nil || limit.Burst <= 0 {
+				return nil, fmt.Errorf("invalid burst in rate limit %q", entry)
+			}
+		}
+
+		limits[name] = limit
+	}
+
+	return limits, nil
+}
+
+func split(s, separator string) (string, string) {
+	parts := strings.SplitN(s, separator, 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	start := time.Now()
+	l := New(Limit{Rate: 1, Burst: 2})
+
+	steps := []struct {
+		name string
+
+		key string
+
+		// at is how long after the first request it is sent
+		at time.Duration
+
+		peek bool
+
+		want Result
+	}{
+		{"first", "a", 0, false, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+		{"burst", "a", 0, false, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}},
+		{"over the burst", "a", 0, false, Result{Limit: 2, RetryAfter: time.Second, Reset: 2 * time.Second}},
+		{"other key", "b", 0, false, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+		{"half refilled", "a", 500 * time.Millisecond, true, Result{Limit: 2, RetryAfter: 500 * time.Millisecond, Reset: 1500 * time.Millisecond}},
+		{"refilled a token", "a", time.Second, false, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}},
+		{"refilled up to the burst", "a", 10 * time.Second, true, Result{Allowed: true, Limit: 2, Remaining: 2}},
+		{"peek took nothing", "a", 10 * time.Second, false, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+	}
+
+	for _, step := range steps {
+		l.now = func() time.Time { return start.Add(step.at) }
+
+		var got Result
+		if step.peek {
+			got = l.Peek(step.key)
+		} else {
+			got = l.Allow(step.key)
+		}
+
+		if got != step.want {
+			t.Errorf("%s: %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		name string
+
+		s string
+
+		limits map[string]Limit
+
+		err bool
+	}{
+		{"empty", "", map[string]Limit{}, false},
+		{"per minute", "default=600/m", map[string]Limit{"default": {Rate: 10, Burst: 600}}, false},
+		{"with a burst", "default=600/m, createOrder=30/m:10,", map[string]Limit{"default": {Rate: 10, Burst: 600}, "createOrder": {Rate: 0.5, Burst: 10}}, false},
+		{"per second and hour", "a=2/s,b=3600/h", map[string]Limit{"a": {Rate: 2, Burst: 2}, "b": {Rate: 1, Burst: 3600}}, false},
+		{"no name", "=1/m", nil, true},
+		{"no count", "a=/m", nil, true},
+		{"zero count", "a=0/m", nil, true},
+		{"unknown unit", "a=1/d", nil, true},
+		{"no unit", "a=1", nil, true},
+		{"zero burst", "a=1/m:0", nil, true},
+		{"invalid burst", "a=1/m:many", nil, true},
+	}
+
+	for _, test := range tests {
+		limits, err := ParseLimits(test.s)
+		if (err != nil) != test.err {
+			t.Errorf("%s: ParseLimits(%q) error %v, want error %v", test.name, test.s, err, test.err)
+			continue
+		}
+
+		if len(limits) != len(test.limits) {
+			t.Errorf("%s: ParseLimits(%q) = %v, want %v", test.name, test.s, limits, test.limits)
+			continue
+		}
+		for name, limit := range test.limits {
+			if limits[name] != limit {
+				t.Errorf("%s: ParseLimits(%q) = %v, want %v", test.name, test.s, limits, test.limits)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/ratelimit"
+)
+
+// defaultLimit names the limit of the routes without one of their own
+const defaultLimit = "default"
+
+// authFailureLimit names the limit of the failed authentications of each IP address, across every route.
+// An address that used it up isn't authenticated until its bucket refills.
+const authFailureLimit = "authFailure"
+
+var (
+	// metrics are served by /metrics
+	metrics = expvar.NewMap("sample_sdk_server")
+
+	// rateLimited counts the requests rejected by the rate limits, by operation ID
+	rateLimited = new(expvar.Map).Init()
+)
+
+func init() {
+	metrics.Set("rate_limited_requests", rateLimited)
+}
+
+// limit lets the request through to handler while its caller is within the limit of limiter.
+// Authenticated callers are limited by key, others by remote IP.
+func limit(operationID string, limiter *ratelimit.Limiter, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := limiter.Allow(callerKey(r))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", seconds(result.Reset))
+
+		if !result.Allowed {
+			rejectLimited(w, operationID, result)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// rejectLimited replies to a request over the limit named name
+func rejectLimited(w http.ResponseWriter, name string, result ratelimit.Result) {
+	rateLimited.Add(name, 1)
+	w.Header().Set("Retry-After", seconds(result.RetryAfter))
+	writeErrorCode(w, http.StatusTooManyRequests, codeRateLimited, "Too many requests, retry after "+seconds(result.RetryAfter)+" seconds")
+}
+
+// callerKey identifies the caller of r for rate limiting
+func callerKey(r *http.Request) string {
+	if principal := principalOf(r); principal != nil {
+		return "key:" + principal.KeyID
+	}
+
+	return ipKey(r)
+}
+
+// ipKey identifies the remote IP address of r for rate limiting
+func ipKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// checkLimitNames checks every limit is named after the operation ID of a route, or is the default
+// or the authentication failure limit, so that a misspelt name doesn't silently fall back to the default
+func checkLimitNames(limits map[string]ratelimit.Limit, routes []route) error {
+	known := map[string]bool{defaultLimit: true, authFailureLimit: true}
+	for _, r := range routes {
+		known[r.operationID] = true
+	}
+
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("rate limit %s doesn't name an operation", name)
+		}
+	}
+
+	return nil
+}
+
+// seconds rounds d up to whole seconds, as rate limit headers expect
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// metricsHandler replies with the server's metrics as JSON
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(metrics.String()))
+}
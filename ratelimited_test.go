@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/instamojo/sample-sdk-server/auth"
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/ratelimit"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	server := startTestServer(t, newFakeInstamojo(), "-rate-limits", "default=600/m,getOrderStatus=2/m")
+	defer server.Close()
+
+	tests := []struct {
+		name string
+
+		path string
+
+		status int
+
+		// headers expected, an empty value for a header that must be missing
+		headers map[string]string
+	}{
+		{"first", "/v1/status?env=test&order_id=GW1", http.StatusOK,
+			map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "1", "X-RateLimit-Reset": "30", "Retry-After": ""}},
+		{"burst", "/v1/status?env=test&order_id=GW1", http.StatusOK,
+			map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "60", "Retry-After": ""}},
+		{"over the limit", "/v1/status?env=test&order_id=GW1", http.StatusTooManyRequests,
+			map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "60", "Retry-After": "30"}},
+		{"unversioned path shares the limit", "/status?env=test&order_id=GW1", http.StatusTooManyRequests,
+			map[string]string{"X-RateLimit-Limit": "2", "Retry-After": "30"}},
+		{"default limit", "/v1/status/stream?env=test&order_id=GW1", http.StatusOK,
+			map[string]string{"X-RateLimit-Limit": "600", "X-RateLimit-Remaining": "599", "Retry-After": ""}},
+	}
+
+	for _, test := range tests {
+		response := server.do(t, "GET", test.path, "")
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+
+		for name, value := range test.headers {
+			if got := response.Header.Get(name); got != value {
+				t.Errorf("%s: %s %q, want %q", test.name, name, got, value)
+			}
+		}
+	}
+}
+
+func TestCheckLimitNames(t *testing.T) {
+	tests := []struct {
+		name string
+
+		limits string
+
+		err string
+	}{
+		{"default and authentication failures", "default=1/m,authFailure=1/m", ""},
+		{"operations", "createOrder=1/m,getOrderStatus=1/m", ""},
+		{"misspelt operation", "createOrders=1/m,createOrder=1/m", "rate limit createOrders doesn't name an operation"},
+		{"first of the unknown names", "zeta=1/m,alpha=1/m", "rate limit alpha doesn't name an operation"},
+	}
+
+	for _, test := range tests {
+		limits, err := ratelimit.ParseLimits(test.limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = checkLimitNames(limits, apiRoutes())
+		if got := errorString(err); got != test.err {
+			t.Errorf("%s: checkLimitNames() error %q, want %q", test.name, got, test.err)
+		}
+	}
+}
+
+func TestAuthFailureLimit(t *testing.T) {
+	keys := writeTestFile(t, `[{"id": "app", "key": "app-key", "scopes": ["order:read"]}]`)
+	defer os.Remove(keys)
+
+	server := startTestServer(t, newFakeInstamojo(), "-auth-keys", keys, "-rate-limits", "default=600/m,authFailure=2/m")
+	defer server.Close()
+
+	tests := []struct {
+		name string
+
+		key string
+
+		status int
+
+		code string
+	}{
+		{"valid key", "app-key", http.StatusOK, ""},
+		{"first failure", "wrong", http.StatusUnauthorized, codeUnauthorized},
+		{"success doesn't count", "app-key", http.StatusOK, ""},
+		{"second failure", "wrong", http.StatusUnauthorized, codeUnauthorized},
+		{"locked out", "wrong", http.StatusTooManyRequests, codeRateLimited},
+		{"locked out with a valid key", "app-key", http.StatusTooManyRequests, codeRateLimited},
+	}
+
+	for _, test := range tests {
+		response := server.do(t, "GET", "/v1/status?env=test&order_id=GW1", "", auth.APIKeyHeader, test.key)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+
+		var reply model.ErrorResponse
+		decodeBody(t, response, &reply)
+		if reply.Code != test.code {
+			t.Errorf("%s: error %q, want %q", test.name, reply.Code, test.code)
+		}
+
+		if test.status == http.StatusTooManyRequests && response.Header.Get("Retry-After") != "30" {
+			t.Errorf("%s: Retry-After %q, want 30", test.name, response.Header.Get("Retry-After"))
+		}
+	}
+}
+
+// errorString returns the message of err, empty when it is nil
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
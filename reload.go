@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/instamojo/sample-sdk-server/auth"
+	"github.com/instamojo/sample-sdk-server/config"
+	"github.com/instamojo/sample-sdk-server/lib"
+	"github.com/instamojo/sample-sdk-server/ratelimit"
+)
+
+// settings are the configuration of the server and what is built from it. They are replaced
+// as a whole on reload, and a request uses the settings current when it arrived until it's done.
+type settings struct {
+	cfg *config.Config
+
+	// clients holds the Instamojo clients of every merchant, by merchant ID then environment
+	clients map[string]map[string]*lib.Client
+
+	// authenticator identifies callers. Requests are not authenticated when it is nil.
+	authenticator auth.Authenticator
+
+	// signatures is part of authenticator, kept to carry its replay cache over to the next settings
+	signatures *auth.Signatures
+
+	// limiters are the rate limiters of handler, kept to carry the requests they counted over to the next settings
+	limiters map[string]*ratelimit.Limiter
+
+	// handler routes the requests, with the rate limits and CORS policy of cfg
+	handler http.Handler
+}
+
+// current holds the *settings in use
+var current atomic.Value
+
+// errAuthRemoved is returned by a reload that would stop authenticating requests
+var errAuthRemoved = errors.New("The new configuration has no auth-keys, turning authentication off takes a restart")
+
+// newSettings builds the settings of cfg. previous is nil at startup.
+func newSettings(cfg *config.Config, previous *settings) (*settings, error) {
+	s := &settings{cfg: cfg}
+
+	if cfg.AuthKeys != "" {
+		keys, err := auth.LoadKeys(cfg.AuthKeys)
+		if err != nil {
+			return nil, fmt.Errorf("loading the API keys: %v", err)
+		}
+
+		if err := checkKeyMerchants(keys, cfg.Registry()); err != nil {
+			return nil, err
+		}
+
+		if previous != nil && previous.signatures != nil {
+			s.signatures = previous.signatures.WithKeys(keys, cfg.SignatureSkew)
+		} else {
+			s.signatures = auth.NewSignatures(keys, cfg.SignatureSkew)
+		}
+		s.authenticator = auth.Chain{auth.NewAPIKeys(keys), s.signatures}
+	}
+
+	limits, err := ratelimit.ParseLimits(cfg.RateLimits)
+	if err == nil {
+		err = checkLimitNames(limits, apiRoutes())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the rate limits: %v", err)
+	}
+
+	// New clients start without a cached access token, so new credentials are used right away
+	s.clients = newClients(cfg)
+
+	cors := CORSPolicy{
+		Origins: cfg.CORSOrigins,
+		Methods: cfg.CORSMethods,
+		Headers: cfg.CORSHeaders,
+		MaxAge:  cfg.CORSMaxAge,
+	}
+	var previousLimiters map[string]*ratelimit.Limiter
+	if previous != nil {
+		previousLimiters = previous.limiters
+	}
+
+	router, limiters := newRouter(apiRoutes(), limits, previousLimiters)
+	s.handler, s.limiters = CORSHandler(cors, router), limiters
+
+	return s, nil
+}
+
+// currentSettings returns the settings in use
+func currentSettings() *settings {
+	return current.Load().(*settings)
+}
+
+// settingsOf returns the settings r is served with
+func settingsOf(r *http.Request) *settings {
+	if s, ok := context.Get(r, settingsKey).(*settings); ok {
+		return s
+	}
+	return currentSettings()
+}
+
+// settingsHandler serves each request with the settings current when it arrives
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	s := currentSettings()
+	context.Set(r, settingsKey, s)
+	defer context.Clear(r)
+
+	s.handler.ServeHTTP(w, r)
+}
+
+// reload loads the configuration again from args, the config file and the environment, and
+// switches to it. The settings in use are kept when the new ones are invalid.
+func reload(args []string) error {
+	cfg, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+
+	previous := currentSettings()
+	if previous.cfg.AuthKeys != "" && cfg.AuthKeys == "" {
+		// Most likely a mistake in the new config file, which would open the API to anyone
+		return errAuthRemoved
+	}
+
+	changed := restartOnly(previous.cfg, cfg)
+
+	// The settings only read at startup keep their running values, which the others must agree with
+	cfg.Port, cfg.Store, cfg.StorePath = previous.cfg.Port, previous.cfg.Store, previous.cfg.StorePath
+	cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout = previous.cfg.ReadTimeout, previous.cfg.WriteTimeout, previous.cfg.IdleTimeout
+	cfg.ShutdownGrace, cfg.IdempotencyTTL, cfg.ReloadInterval = previous.cfg.ShutdownGrace, previous.cfg.IdempotencyTTL, previous.cfg.ReloadInterval
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s, err := newSettings(cfg, previous)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range changed {
+		log.Printf("Setting %s changed, it takes effect after a restart", name)
+	}
+
+	current.Store(s)
+	return nil
+}
+
+// restartOnly lists the settings changed from old to new that are only read at startup
+func restartOnly(old, new *config.Config) []string {
+	fixed := []struct {
+		name string
+
+		changed bool
+	}{
+		{"port", old.Port != new.Port},
+		{"store", old.Store != new.Store},
+		{"store-path", old.StorePath != new.StorePath},
+		{"read-timeout", old.ReadTimeout != new.ReadTimeout},
+		{"write-timeout", old.WriteTimeout != new.WriteTimeout},
+		{"idle-timeout", old.IdleTimeout != new.IdleTimeout},
+		{"shutdown-grace", old.ShutdownGrace != new.ShutdownGrace},
+		{"idempotency-ttl", old.IdempotencyTTL != new.IdempotencyTTL},
+		{"reload-interval", old.ReloadInterval != new.ReloadInterval},
+	}
+
+	var changed []string
+	for _, setting := range fixed {
+		if setting.changed {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// reloadOnChange reloads the configuration on SIGHUP, and when the config file or the keys
+// file changes if interval isn't 0. Files are compared by their modification time and size.
+func reloadOnChange(args []string, interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	stamps := fileStamps(currentSettings().cfg)
+	for {
+		select {
+		case <-hangups:
+			log.Println("Received SIGHUP, reloading the configuration")
+			stamps = fileStamps(currentSettings().cfg)
+		case <-ticks:
+			latest := fileStamps(currentSettings().cfg)
+			if latest == stamps {
+				continue
+			}
+
+			// A failed reload isn't retried until the files change again
+			stamps = latest
+			log.Println("Configuration files changed, reloading the configuration")
+		}
+
+		if err := reload(args); err != nil {
+			log.Printf("Reloading the configuration failed, keeping the current one. Error %v", err)
+			continue
+		}
+		log.Println("Configuration reloaded")
+	}
+}
+
+// fileStamp identifies a version of a file. The zero value stands for a missing file.
+type fileStamp struct {
+	modTime time.Time
+
+	size int64
+}
+
+// fileStamps returns the stamps of the config file and the keys file of cfg
+func fileStamps(cfg *config.Config) [2]fileStamp {
+	var stamps [2]fileStamp
+	for i, path := range []string{cfg.File, cfg.AuthKeys} {
+		if path == "" {
+			continue
+		}
+
+		if info, err := os.Stat(path); err == nil {
+			stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamps
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReloadRejected(t *testing.T) {
+	keys := writeTestFile(t, `[{"id": "app", "key": "app-key", "scopes": ["order:read"]}]`)
+	defer os.Remove(keys)
+
+	tests := []struct {
+		name string
+
+		args []string
+
+		err string
+	}{
+		{"invalid rate limits", []string{"-auth-keys", keys, "-rate-limits", "default=many"}, "reading the rate limits"},
+		{"invalid setting", []string{"-auth-keys", keys, "-stream-poll-interval", "0s"}, "Stream poll interval must be positive"},
+		{"missing keys file", []string{"-auth-keys", keys + ".missing"}, "loading the API keys"},
+		{"authentication removed", nil, errAuthRemoved.Error()},
+	}
+
+	for _, test := range tests {
+		server := startTestServer(t, newFakeInstamojo(), "-auth-keys", keys)
+		before := currentSettings()
+
+		err := reload(append(testArgs(server.upstream.URL), test.args...))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: reload() error %v, want one containing %q", test.name, err, test.err)
+		}
+
+		if currentSettings() != before {
+			t.Errorf("%s: the settings were replaced", test.name)
+		}
+
+		// The settings kept still authenticate requests
+		response := server.do(t, "GET", "/v1/status?env=test&order_id=GW1", "")
+		response.Body.Close()
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status %d without a key, want %d", test.name, response.StatusCode, http.StatusUnauthorized)
+		}
+
+		server.Close()
+	}
+}
+
+func TestReloadCredentials(t *testing.T) {
+	instamojo := newFakeInstamojo()
+	server := startTestServer(t, instamojo)
+	defer server.Close()
+
+	steps := []struct {
+		name string
+
+		// args are added to the settings of the server for the reload, none for a request without one
+		args []string
+
+		reload bool
+
+		// tokens are the access tokens granted so far, by client ID
+		tokens map[string]int
+	}{
+		{"first request", nil, false, map[string]int{"test-id": 1}},
+		{"token cached", nil, false, map[string]int{"test-id": 1}},
+		{"same credentials", nil, true, map[string]int{"test-id": 2}},
+		{"new credentials", []string{"-test-client-id", "new-test-id"}, true, map[string]int{"test-id": 2, "new-test-id": 1}},
+		{"new token cached", nil, false, map[string]int{"test-id": 2, "new-test-id": 1}},
+	}
+
+	args := testArgs(server.upstream.URL)
+	for _, step := range steps {
+		if step.reload {
+			args = append(testArgs(server.upstream.URL), step.args...)
+			if err := reload(args); err != nil {
+				t.Fatalf("%s: reload() error %v", step.name, err)
+			}
+		}
+
+		response := server.do(t, "GET", "/v1/status?env=test&order_id=GW1", "")
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d, want %d", step.name, response.StatusCode, http.StatusOK)
+		}
+
+		for clientID, tokens := range step.tokens {
+			if got := instamojo.tokensOf(clientID); got != tokens {
+				t.Errorf("%s: %d tokens granted to %s, want %d", step.name, got, clientID, tokens)
+			}
+		}
+	}
+}
+
+func TestReloadKeepsRateLimits(t *testing.T) {
+	server := startTestServer(t, newFakeInstamojo(), "-rate-limits", "default=600/m,getOrderStatus=1/m,authFailure=5/m")
+	defer server.Close()
+
+	steps := []struct {
+		name string
+
+		// limits are reloaded before the request, when not empty
+		limits string
+
+		status int
+	}{
+		{"first request", "", http.StatusOK},
+		{"over the limit", "", http.StatusTooManyRequests},
+		{"limits unchanged", "default=600/m,getOrderStatus=1/m,authFailure=5/m", http.StatusTooManyRequests},
+		{"other limits changed", "default=300/m,getOrderStatus=1/m,authFailure=10/m", http.StatusTooManyRequests},
+		{"limit changed", "default=300/m,getOrderStatus=2/m,authFailure=10/m", http.StatusOK},
+	}
+
+	for _, step := range steps {
+		if step.limits != "" {
+			before := currentSettings().limiters
+			if err := reload(append(testArgs(server.upstream.URL), "-rate-limits", step.limits)); err != nil {
+				t.Fatalf("%s: reload() error %v", step.name, err)
+			}
+
+			for name, limiter := range currentSettings().limiters {
+				if kept := before[name] == limiter; kept != (before[name] != nil && before[name].Limit() == limiter.Limit()) {
+					t.Errorf("%s: limiter %s kept %v, want it kept only when its limit is unchanged", step.name, name, kept)
+				}
+			}
+		}
+
+		response := server.do(t, "GET", "/v1/status?env=test&order_id=GW1", "")
+		response.Body.Close()
+		if response.StatusCode != step.status {
+			t.Errorf("%s: status %d, want %d", step.name, response.StatusCode, step.status)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/instamojo/sample-sdk-server/auth"
+	"github.com/instamojo/sample-sdk-server/lib"
+	"github.com/instamojo/sample-sdk-server/model"
+	"github.com/instamojo/sample-sdk-server/openapi"
+	"github.com/instamojo/sample-sdk-server/ratelimit"
+)
+
+// apiVersion prefixes the path of every route
+const apiVersion = "/v1"
+
+// route is an endpoint of the API. The router and the OpenAPI document are both built from the routes.
+type route struct {
+	method string
+
+	// path without the version prefix
+	path string
+
+	handler http.HandlerFunc
+
+	// scope the caller must be granted, empty for routes open to anyone
+	scope string
+
+	operationID string
+
+	summary string
+
+	parameters []openapi.Parameter
+
+	// jsonBody and formBody are values of the type of the request body, if any
+	jsonBody interface{}
+
+	formBody interface{}
+
+	status int
+
+	// contentType of the response, JSON if empty
+	contentType string
+
+	// response is a value of the type of the response body, nil if there is none
+	response interface{}
+}
+
+var envParameter = openapi.Parameter{
+	Name:        "env",
+	In:          "query",
+	Description: "test or production, test if empty",
+	Schema:      &openapi.Schema{Type: "string", Enum: []string{lib.TestENV, lib.ProdENV}},
+}
+
+// apiRoutes lists the endpoints of the API
+func apiRoutes() []route {
+	return []route{
+		{
+			method:      "POST",
+			path:        "/order",
+			handler:     createOrder,
+			scope:       auth.ScopeOrderCreate,
+			operationID: "createOrder",
+			summary:     "Create an order to pay with the Instamojo SDK",
+			parameters: []openapi.Parameter{{
+				Name:        idempotencyKeyHeader,
+				In:          "header",
+				Description: "Retries with the same key and body return the order created first",
+				Schema:      openapi.String(""),
+			}},
+			jsonBody: model.GetOrderIDRequest{},
+			status:   http.StatusOK,
+			response: model.Order{},
+		},
+		{
+			method:      "GET",
+			path:        "/status",
+			handler:     statusHandler,
+			scope:       auth.ScopeOrderRead,
+			operationID: "getOrderStatus",
+			summary:     "Get the status of an order by its order ID or transaction ID",
+			parameters: []openapi.Parameter{
+				envParameter,
+				{Name: "order_id", In: "query", Schema: openapi.String("")},
+				{Name: "transaction_id", In: "query", Schema: openapi.String("")},
+				{Name: "compact", In: "query", Description: "true for the compact GatewayOrderStatus", Schema: &openapi.Schema{Type: "boolean"}},
+			},
+			status:   http.StatusOK,
+			response: model.OrderDetails{},
+		},
+		{
+			method:      "POST",
+			path:        "/status/batch",
+			handler:     statusBatchHandler,
+			scope:       auth.ScopeOrderRead,
+			operationID: "getOrderStatusBatch",
+			summary:     "Get the full status of several orders, each with its own result or error",
+			jsonBody:    model.StatusBatchRequest{},
+			status:      http.StatusOK,
+			response:    model.StatusBatchResponse{},
+		},
+		{
+			method:      "GET",
+			path:        "/status/stream",
+			handler:     statusStreamHandler,
+			scope:       auth.ScopeOrderRead,
+			operationID: "streamOrderStatus",
+			summary: "Stream the full status of an order as Server-Sent Events: a status event for every change, " +
+				"then end once the order is final, or timeout, or shutdown when the server stops",
+			parameters: []openapi.Parameter{
+				envParameter,
+				{Name: "order_id", In: "query", Required: true, Schema: openapi.String("")},
+			},
+			status:      http.StatusOK,
+			contentType: "text/event-stream",
+			response:    model.OrderDetails{},
+		},
+		{
+			method:      "POST",
+			path:        "/refund",
+			handler:     refundHandler,
+			scope:       auth.ScopeRefundCreate,
+			operationID: "createRefund",
+			summary:     "Refund the payment of an order",
+			formBody:    model.RefundRequest{},
+			status:      http.StatusCreated,
+			response:    model.Refund{},
+		},
+		{
+			method:      "GET",
+			path:        "/refund/{id}",
+			handler:     refundStatusHandler,
+			scope:       auth.ScopeOrderRead,
+			operationID: "getRefund",
+			summary:     "Get a refund by its Instamojo ID",
+			parameters: []openapi.Parameter{
+				envParameter,
+				{Name: "id", In: "path", Required: true, Schema: openapi.String("")},
+			},
+			status:   http.StatusOK,
+			response: model.Refund{},
+		},
+		{
+			method:      "GET",
+			path:        "/refunds",
+			handler:     refundLedgerHandler,
+			scope:       auth.ScopeOrderRead,
+			operationID: "getRefundLedger",
+			summary:     "Get the refunds of the payment of an order and their totals",
+			parameters: []openapi.Parameter{
+				envParameter,
+				{Name: "transaction_id", In: "query", Required: true, Schema: openapi.String("")},
+			},
+			status:   http.StatusOK,
+			response: model.RefundLedger{},
+		},
+		{
+			method:      "GET",
+			path:        "/ping",
+			handler:     pingHandler,
+			operationID: "ping",
+			summary:     "Check the server is up",
+			status:      http.StatusOK,
+		},
+		{
+			method:      "POST",
+			path:        "/webhook/instamojo",
+			handler:     webhookHandler,
+			operationID: "receiveWebhook",
+			summary:     "Payment notifications sent by Instamojo",
+			status:      http.StatusOK,
+		},
+	}
+}
+
+// newRouter mounts routes under apiVersion. The unversioned paths are kept as deprecated aliases.
+// Each route is limited by the limit named after its operation ID, or else by the default limit.
+// Failed authentications on any route share the authentication failure limit.
+// The limiters of previous whose limit is unchanged are kept, with the requests they counted,
+// and the limiters in use are returned by operation ID or limit name.
+func newRouter(routes []route, limits map[string]ratelimit.Limit, previous map[string]*ratelimit.Limiter) (*mux.Router, map[string]*ratelimit.Limiter) {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+
+	limiters := map[string]*ratelimit.Limiter{}
+	limiterOf := func(name string, limit ratelimit.Limit) *ratelimit.Limiter {
+		limiter, ok := previous[name]
+		if !ok || limiter.Limit() != limit {
+			limiter = ratelimit.New(limit)
+		}
+		limiters[name] = limiter
+		return limiter
+	}
+
+	var failures *ratelimit.Limiter
+	if failureLimit, ok := limits[authFailureLimit]; ok {
+		failures = limiterOf(authFailureLimit, failureLimit)
+	}
+
+	for _, r := range routes {
+		handler := r.handler
+		routeLimit, ok := limits[r.operationID]
+		if !ok {
+			routeLimit, ok = limits[defaultLimit]
+		}
+
+		if ok {
+			handler = limit(r.operationID, limiterOf(r.operationID, routeLimit), handler)
+		}
+
+		if r.scope != "" {
+			handler = authorize(r.scope, failures, handler)
+		}
+
+		router.HandleFunc(apiVersion+r.path, handler).Methods(r.method)
+		router.HandleFunc(r.path, deprecated(handler)).Methods(r.method)
+	}
+
+	document := apiDocument(routes)
+	router.HandleFunc(apiVersion+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, document)
+	}).Methods("GET")
+	router.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	router.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", readyzHandler).Methods("GET")
+
+	return router, limiters
+}
+
+// deprecated marks the replies of an unversioned path, pointing to its versioned successor
+func deprecated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiVersion, r.URL.Path))
+		handler(w, r)
+	}
+}
+
+// apiDocument describes the versioned routes as an OpenAPI document
+func apiDocument(routes []route) *openapi.Document {
+	builder := openapi.NewBuilder(openapi.Info{
+		Title:       "Instamojo sample SDK server",
+		Version:     "1",
+		Description: "Creates Instamojo orders for the mobile SDKs and manages their payments and refunds",
+	})
+
+	builder.AddSecurityScheme("apiKey", openapi.SecurityScheme{
+		Type: "apiKey",
+		In:   "header",
+		Name: auth.APIKeyHeader,
+	})
+	builder.AddSecurityScheme("signature", openapi.SecurityScheme{
+		Type: "apiKey",
+		In:   "header",
+		Name: auth.SignatureHeader,
+		Description: "Hex HMAC-SHA256 of the method, the path with its query, the X-Timestamp Unix time and the hex SHA-256 of the body, " +
+			"joined with newlines and signed with the secret of the X-Key-ID key",
+	})
+
+	// Only referenced by the description of the compact parameter of /status
+	builder.SchemaOf(model.GatewayOrderStatus{})
+
+	errorResponse := openapi.Response{
+		Description: "Error",
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: builder.SchemaOf(model.ErrorResponse{})}},
+	}
+
+	for _, r := range routes {
+		operation := &openapi.Operation{
+			Summary:     r.summary,
+			OperationID: r.operationID,
+			Parameters:  r.parameters,
+			Responses:   map[string]openapi.Response{"default": errorResponse},
+		}
+
+		if r.scope != "" {
+			operation.Description = "Needs the " + r.scope + " scope"
+			operation.Security = []map[string][]string{{"apiKey": {}}, {"signature": {}}}
+		}
+
+		switch {
+		case r.jsonBody != nil:
+			operation.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]openapi.MediaType{"application/json": {Schema: builder.SchemaOf(r.jsonBody)}},
+			}
+		case r.formBody != nil:
+			operation.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]openapi.MediaType{"application/x-www-form-urlencoded": {Schema: builder.SchemaOf(r.formBody)}},
+			}
+		}
+
+		response := openapi.Response{Description: http.StatusText(r.status)}
+		if r.response != nil {
+			contentType := r.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			response.Content = map[string]openapi.MediaType{contentType: {Schema: builder.SchemaOf(r.response)}}
+		}
+		operation.Responses[fmt.Sprint(r.status)] = response
+
+		builder.Add(r.method, apiVersion+r.path, operation)
+	}
+
+	return builder.Document()
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// orders by transaction ID
+	ordersBucket = []byte("orders")
+
+	// transaction IDs by order ID
+	orderIDsBucket = []byte("order_ids")
+
+	// transaction IDs by payment request ID
+	paymentRequestIDsBucket = []byte("payment_request_ids")
+
+	// refunds by refund transaction ID
+	refundsBucket = []byte("refunds")
+
+	// refund transaction IDs by Instamojo refund ID
+	refundIDsBucket = []byte("refund_ids")
+
+	// one nested bucket per payment ID, holding the transaction IDs of its refunds
+	paymentRefundsBucket = []byte("payment_refunds")
+
+	// webhook notifications by sequence, in the order they were received
+	notificationsBucket = []byte("notifications")
+
+	// time of the first delivery by webhook delivery key
+	deliveriesBucket = []byte("deliveries")
+)
+
+// BoltStore keeps everything in a BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens the BoltDB file at path, creating it if needed
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{ordersBucket, orderIDsBucket, paymentRequestIDsBucket, refundsBucket, refundIDsBucket, paymentRefundsBucket, notificationsBucket, deliveriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// SaveOrder implements OrderStore
+func (b *BoltStore) SaveOrder(order *model.OrderRecord) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		orders := tx.Bucket(ordersBucket)
+		orderIDs := tx.Bucket(orderIDsBucket)
+
+		var previous model.OrderRecord
+		if found, err := get(orders, order.TransactionID, &previous); err != nil {
+			return err
+		} else if found && previous.OrderID != "" && previous.OrderID != order.OrderID {
+			if err := orderIDs.Delete([]byte(previous.OrderID)); err != nil {
+				return err
+			}
+		}
+
+		if order.OrderID != "" {
+			if err := orderIDs.Put([]byte(order.OrderID), []byte(order.TransactionID)); err != nil {
+				return err
+			}
+		}
+
+		if order.PaymentRequestID != "" {
+			if err := tx.Bucket(paymentRequestIDsBucket).Put([]byte(order.PaymentRequestID), []byte(order.TransactionID)); err != nil {
+				return err
+			}
+		}
+
+		return put(orders, order.TransactionID, order)
+	})
+}
+
+// OrderByTransactionID implements OrderStore
+func (b *BoltStore) OrderByTransactionID(transactionID string) (*model.OrderRecord, error) {
+	var order model.OrderRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return mustGet(tx.Bucket(ordersBucket), transactionID, &order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// OrderByOrderID implements OrderStore
+func (b *BoltStore) OrderByOrderID(orderID string) (*model.OrderRecord, error) {
+	return b.orderByIndex(orderIDsBucket, orderID)
+}
+
+// OrderByPaymentRequestID implements OrderStore
+func (b *BoltStore) OrderByPaymentRequestID(paymentRequestID string) (*model.OrderRecord, error) {
+	return b.orderByIndex(paymentRequestIDsBucket, paymentRequestID)
+}
+
+// orderByIndex returns the order whose transaction ID is stored under key in the index bucket
+func (b *BoltStore) orderByIndex(index []byte, key string) (*model.OrderRecord, error) {
+	var order model.OrderRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		transactionID := tx.Bucket(index).Get([]byte(key))
+		if transactionID == nil {
+			return ErrNotFound
+		}
+
+		return mustGet(tx.Bucket(ordersBucket), string(transactionID), &order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// SavePayment implements OrderStore
+func (b *BoltStore) SavePayment(transactionID string, payment model.Payment) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		orders := tx.Bucket(ordersBucket)
+
+		var order model.OrderRecord
+		if err := mustGet(orders, transactionID, &order); err != nil {
+			return err
+		}
+
+		order.Payments = upsertPayment(order.Payments, payment)
+		order.UpdatedAt = time.Now()
+		return put(orders, transactionID, &order)
+	})
+}
+
+// SaveRefund implements OrderStore
+func (b *BoltStore) SaveRefund(refund *model.Refund) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		paymentRefunds, err := tx.Bucket(paymentRefundsBucket).CreateBucketIfNotExists([]byte(refund.PaymentID))
+		if err != nil {
+			return err
+		}
+
+		refunds := tx.Bucket(refundsBucket)
+		if refunds.Get([]byte(refund.TransactionID)) == nil {
+			// Sequence keeps the refunds of a payment in creation order
+			sequence, err := paymentRefunds.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			if err := paymentRefunds.Put(sequenceKey(sequence), []byte(refund.TransactionID)); err != nil {
+				return err
+			}
+		}
+
+		if refund.ID != "" {
+			if err := tx.Bucket(refundIDsBucket).Put([]byte(refund.ID), []byte(refund.TransactionID)); err != nil {
+				return err
+			}
+		}
+
+		return put(refunds, refund.TransactionID, refund)
+	})
+}
+
+// RefundByID implements OrderStore
+func (b *BoltStore) RefundByID(refundID string) (*model.Refund, error) {
+	var refund model.Refund
+	err := b.db.View(func(tx *bolt.Tx) error {
+		transactionID := tx.Bucket(refundIDsBucket).Get([]byte(refundID))
+		if transactionID == nil {
+			return ErrNotFound
+		}
+
+		return mustGet(tx.Bucket(refundsBucket), string(transactionID), &refund)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &refund, nil
+}
+
+// RefundsByPaymentID implements OrderStore
+func (b *BoltStore) RefundsByPaymentID(paymentID string) ([]*model.Refund, error) {
+	refunds := []*model.Refund{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		paymentRefunds := tx.Bucket(paymentRefundsBucket).Bucket([]byte(paymentID))
+		if paymentRefunds == nil {
+			return nil
+		}
+
+		all := tx.Bucket(refundsBucket)
+		return paymentRefunds.ForEach(func(_, transactionID []byte) error {
+			var refund model.Refund
+			if err := mustGet(all, string(transactionID), &refund); err != nil {
+				return err
+			}
+
+			refunds = append(refunds, &refund)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// SaveNotification implements OrderStore
+func (b *BoltStore) SaveNotification(notification *model.Notification) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		notifications := tx.Bucket(notificationsBucket)
+		sequence, err := notifications.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(notification)
+		if err != nil {
+			return err
+		}
+
+		return notifications.Put(sequenceKey(sequence), data)
+	})
+}
+
+// Delivered implements OrderStore
+func (b *BoltStore) Delivered(key string) (bool, error) {
+	delivered := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		delivered = tx.Bucket(deliveriesBucket).Get([]byte(key)) != nil
+		return nil
+	})
+
+	return delivered, err
+}
+
+// RecordDelivery implements OrderStore
+func (b *BoltStore) RecordDelivery(key string) (bool, error) {
+	duplicate := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		deliveries := tx.Bucket(deliveriesBucket)
+		if deliveries.Get([]byte(key)) != nil {
+			duplicate = true
+			return nil
+		}
+
+		return deliveries.Put([]byte(key), []byte(time.Now().Format(time.RFC3339)))
+	})
+
+	return duplicate, err
+}
+
+// Ping implements OrderStore. It fails once the database is closed.
+func (b *BoltStore) Ping() error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// Close implements OrderStore
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// put stores v as JSON under key
+func put(bucket *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(key), data)
+}
+
+// get decodes the JSON stored under key into v, reporting whether there was one
+func get(bucket *bolt.Bucket, key string, v interface{}) (bool, error) {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(data, v)
+}
+
+// mustGet is get for records that must exist. It returns ErrNotFound when there is none.
+func mustGet(bucket *bolt.Bucket, key string, v interface{}) error {
+	found, err := get(bucket, key, v)
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// sequenceKey encodes n so that keys sort in numeric order
+func sequenceKey(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// MemoryStore keeps everything in memory. Its content is lost when the server stops,
+// so it is only meant for development and tests.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	// orders by transaction ID
+	orders map[string]*model.OrderRecord
+
+	// transaction IDs by order ID
+	orderIDs map[string]string
+
+	// transaction IDs by payment request ID
+	paymentRequestIDs map[string]string
+
+	// refunds by refund transaction ID
+	refunds map[string]*model.Refund
+
+	// refund transaction IDs by Instamojo refund ID
+	refundIDs map[string]string
+
+	// refund transaction IDs by payment ID, oldest first
+	paymentRefunds map[string][]string
+
+	// notifications in the order they were received
+	notifications []*model.Notification
+
+	// keys of the webhook deliveries recorded so far
+	deliveries map[string]time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		orders:            map[string]*model.OrderRecord{},
+		orderIDs:          map[string]string{},
+		paymentRequestIDs: map[string]string{},
+		refunds:           map[string]*model.Refund{},
+		refundIDs:         map[string]string{},
+		paymentRefunds:    map[string][]string{},
+		deliveries:        map[string]time.Time{},
+	}
+}
+
+// SaveOrder implements OrderStore
+func (m *MemoryStore) SaveOrder(order *model.OrderRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if previous, ok := m.orders[order.TransactionID]; ok && previous.OrderID != order.OrderID {
+		delete(m.orderIDs, previous.OrderID)
+	}
+
+	m.orders[order.TransactionID] = copyOrder(order)
+	if order.OrderID != "" {
+		m.orderIDs[order.OrderID] = order.TransactionID
+	}
+
+	if order.PaymentRequestID != "" {
+		m.paymentRequestIDs[order.PaymentRequestID] = order.TransactionID
+	}
+
+	return nil
+}
+
+// OrderByTransactionID implements OrderStore
+func (m *MemoryStore) OrderByTransactionID(transactionID string) (*model.OrderRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, ok := m.orders[transactionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyOrder(order), nil
+}
+
+// OrderByOrderID implements OrderStore
+func (m *MemoryStore) OrderByOrderID(orderID string) (*model.OrderRecord, error) {
+	m.mu.RLock()
+	transactionID, ok := m.orderIDs[orderID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return m.OrderByTransactionID(transactionID)
+}
+
+// OrderByPaymentRequestID implements OrderStore
+func (m *MemoryStore) OrderByPaymentRequestID(paymentRequestID string) (*model.OrderRecord, error) {
+	m.mu.RLock()
+	transactionID, ok := m.paymentRequestIDs[paymentRequestID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return m.OrderByTransactionID(transactionID)
+}
+
+// SavePayment implements OrderStore
+func (m *MemoryStore) SavePayment(transactionID string, payment model.Payment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.orders[transactionID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	order.Payments = upsertPayment(order.Payments, payment)
+	order.UpdatedAt = time.Now()
+	return nil
+}
+
+// SaveRefund implements OrderStore
+func (m *MemoryStore) SaveRefund(refund *model.Refund) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.refunds[refund.TransactionID]; !ok {
+		m.paymentRefunds[refund.PaymentID] = append(m.paymentRefunds[refund.PaymentID], refund.TransactionID)
+	}
+
+	saved := *refund
+	m.refunds[refund.TransactionID] = &saved
+	if refund.ID != "" {
+		m.refundIDs[refund.ID] = refund.TransactionID
+	}
+	return nil
+}
+
+// RefundByID implements OrderStore
+func (m *MemoryStore) RefundByID(refundID string) (*model.Refund, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	transactionID, ok := m.refundIDs[refundID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	found := *m.refunds[transactionID]
+	return &found, nil
+}
+
+// RefundsByPaymentID implements OrderStore
+func (m *MemoryStore) RefundsByPaymentID(paymentID string) ([]*model.Refund, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refunds := make([]*model.Refund, 0, len(m.paymentRefunds[paymentID]))
+	for _, transactionID := range m.paymentRefunds[paymentID] {
+		refund := *m.refunds[transactionID]
+		refunds = append(refunds, &refund)
+	}
+
+	return refunds, nil
+}
+
+// SaveNotification implements OrderStore
+func (m *MemoryStore) SaveNotification(notification *model.Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *notification
+	m.notifications = append(m.notifications, &saved)
+	return nil
+}
+
+// Delivered implements OrderStore
+func (m *MemoryStore) Delivered(key string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.deliveries[key]
+	return ok, nil
+}
+
+// RecordDelivery implements OrderStore
+func (m *MemoryStore) RecordDelivery(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.deliveries[key]; ok {
+		return true, nil
+	}
+
+	m.deliveries[key] = time.Now()
+	return false, nil
+}
+
+// Ping implements OrderStore
+func (m *MemoryStore) Ping() error {
+	return nil
+}
+
+// Close implements OrderStore
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// copyOrder returns a copy of order that shares no memory with it
+func copyOrder(order *model.OrderRecord) *model.OrderRecord {
+	copied := *order
+	copied.Payments = append([]model.Payment(nil), order.Payments...)
+	return &copied
+}
@@ -0,0 +1,86 @@
+// Package store keeps the orders, payments and refunds handled by the server
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// ErrNotFound is returned when no record matches the lookup
+var ErrNotFound = errors.New("store: not found")
+
+// OrderStore saves and loads orders along with their payments and refunds.
+// Implementations must be safe for concurrent use.
+type OrderStore interface {
+	// SaveOrder creates the order, or replaces the one with the same transaction ID
+	SaveOrder(order *model.OrderRecord) error
+
+	// OrderByTransactionID returns the order created with transactionID
+	OrderByTransactionID(transactionID string) (*model.OrderRecord, error)
+
+	// OrderByOrderID returns the order with the Instamojo orderID
+	OrderByOrderID(orderID string) (*model.OrderRecord, error)
+
+	// OrderByPaymentRequestID returns the order of the Instamojo gateway order paymentRequestID
+	OrderByPaymentRequestID(paymentRequestID string) (*model.OrderRecord, error)
+
+	// SavePayment adds the payment to the order of transactionID,
+	// replacing an earlier version of the same payment
+	SavePayment(transactionID string, payment model.Payment) error
+
+	// SaveRefund creates the refund, or replaces the one with the same transaction ID
+	SaveRefund(refund *model.Refund) error
+
+	// RefundByID returns the refund with the Instamojo refund ID
+	RefundByID(refundID string) (*model.Refund, error)
+
+	// RefundsByPaymentID returns the refunds of a payment, oldest first
+	RefundsByPaymentID(paymentID string) ([]*model.Refund, error)
+
+	// SaveNotification keeps a webhook notification as received, whether or not it was valid
+	SaveNotification(notification *model.Notification) error
+
+	// Delivered reports whether key was marked as delivered
+	Delivered(key string) (bool, error)
+
+	// RecordDelivery marks key as delivered. It reports whether key was delivered before.
+	RecordDelivery(key string) (duplicate bool, err error)
+
+	// Ping checks the store can serve requests
+	Ping() error
+
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// Memory is the driver name of the in-memory store
+const Memory = "memory"
+
+// Bolt is the driver name of the BoltDB file store
+const Bolt = "bolt"
+
+// New returns the store for driver. path is the database file and is only used by file backed stores.
+func New(driver, path string) (OrderStore, error) {
+	switch driver {
+	case Memory:
+		return NewMemoryStore(), nil
+	case Bolt:
+		return NewBoltStore(path)
+	default:
+		return nil, fmt.Errorf("store: unknown driver %q", driver)
+	}
+}
+
+// upsertPayment replaces the payment with the same ID in payments, or appends it
+func upsertPayment(payments []model.Payment, payment model.Payment) []model.Payment {
+	for i := range payments {
+		if payments[i].ID == payment.ID {
+			payments[i] = payment
+			return payments
+		}
+	}
+
+	return append(payments, payment)
+}
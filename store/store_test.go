@@ -0,0 +1,207 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/instamojo/sample-sdk-server/model"
+)
+
+// forEachStore runs test against an empty store of every driver
+func forEachStore(t *testing.T, test func(t *testing.T, s OrderStore)) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, driver := range []string{Memory, Bolt} {
+		t.Run(driver, func(t *testing.T) {
+			s, err := New(driver, filepath.Join(dir, driver+".db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			test(t, s)
+		})
+	}
+}
+
+func TestOrderIndexes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s OrderStore) {
+		order := &model.OrderRecord{TransactionID: "order-1", PaymentRequestID: "GW1", Status: "pending"}
+
+		steps := []struct {
+			name string
+
+			// orderID is saved as the order ID of the order
+			orderID string
+
+			// found and missing are the order IDs the order is and isn't found by
+			found, missing []string
+		}{
+			{"created", "", nil, []string{""}},
+			{"order created", "ORDER1", []string{"ORDER1"}, nil},
+			{"order replaced", "ORDER2", []string{"ORDER2"}, []string{"ORDER1"}},
+			{"saved again", "ORDER2", []string{"ORDER2"}, []string{"ORDER1"}},
+		}
+
+		for _, step := range steps {
+			order.OrderID = step.orderID
+			if err := s.SaveOrder(order); err != nil {
+				t.Fatalf("%s: SaveOrder() error %v", step.name, err)
+			}
+
+			for _, orderID := range step.found {
+				if found, err := s.OrderByOrderID(orderID); err != nil || found.TransactionID != "order-1" {
+					t.Errorf("%s: OrderByOrderID(%q) = %+v, %v, want order-1", step.name, orderID, found, err)
+				}
+			}
+
+			for _, orderID := range step.missing {
+				if found, err := s.OrderByOrderID(orderID); err != ErrNotFound {
+					t.Errorf("%s: OrderByOrderID(%q) = %+v, %v, want %v", step.name, orderID, found, err, ErrNotFound)
+				}
+			}
+
+			if found, err := s.OrderByPaymentRequestID("GW1"); err != nil || found.OrderID != step.orderID {
+				t.Errorf("%s: OrderByPaymentRequestID(GW1) = %+v, %v, want order ID %q", step.name, found, err, step.orderID)
+			}
+		}
+
+		if _, err := s.OrderByTransactionID("order-2"); err != ErrNotFound {
+			t.Errorf("OrderByTransactionID(order-2) error %v, want %v", err, ErrNotFound)
+		}
+
+		if _, err := s.OrderByPaymentRequestID("GW2"); err != ErrNotFound {
+			t.Errorf("OrderByPaymentRequestID(GW2) error %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestSavePayment(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s OrderStore) {
+		if err := s.SavePayment("order-1", model.Payment{ID: "MOJO1"}); err != ErrNotFound {
+			t.Errorf("SavePayment() to a missing order error %v, want %v", err, ErrNotFound)
+		}
+
+		if err := s.SaveOrder(&model.OrderRecord{TransactionID: "order-1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		payments := []model.Payment{
+			{ID: "MOJO1", Status: "pending"},
+			{ID: "MOJO2", Status: "failed"},
+			{ID: "MOJO1", Status: "successful"},
+		}
+		for _, payment := range payments {
+			if err := s.SavePayment("order-1", payment); err != nil {
+				t.Fatalf("SavePayment(%+v) error %v", payment, err)
+			}
+		}
+
+		order, err := s.OrderByTransactionID("order-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(order.Payments) != 2 || order.Payments[0] != payments[2] || order.Payments[1] != payments[1] {
+			t.Errorf("payments %+v, want MOJO1 successful then MOJO2 failed", order.Payments)
+		}
+	})
+}
+
+func TestRefundsByPaymentID(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s OrderStore) {
+		refunds := []*model.Refund{
+			{TransactionID: "refund-3", PaymentID: "MOJO1", Status: "requested"},
+			{TransactionID: "refund-1", PaymentID: "MOJO1", Status: "requested"},
+			{TransactionID: "refund-other", PaymentID: "MOJO2", Status: "requested"},
+			{TransactionID: "refund-2", PaymentID: "MOJO1", Status: "requested"},
+			// Accepted by Instamojo, which keeps its place
+			{ID: "C3", TransactionID: "refund-3", PaymentID: "MOJO1", Status: "pending"},
+		}
+		for _, refund := range refunds {
+			if err := s.SaveRefund(refund); err != nil {
+				t.Fatalf("SaveRefund(%+v) error %v", refund, err)
+			}
+		}
+
+		tests := []struct {
+			paymentID string
+
+			transactionIDs []string
+		}{
+			{"MOJO1", []string{"refund-3", "refund-1", "refund-2"}},
+			{"MOJO2", []string{"refund-other"}},
+			{"MOJO3", nil},
+		}
+
+		for _, test := range tests {
+			found, err := s.RefundsByPaymentID(test.paymentID)
+			if err != nil {
+				t.Fatalf("RefundsByPaymentID(%s) error %v", test.paymentID, err)
+			}
+
+			var transactionIDs []string
+			for _, refund := range found {
+				transactionIDs = append(transactionIDs, refund.TransactionID)
+			}
+
+			if len(transactionIDs) != len(test.transactionIDs) {
+				t.Errorf("RefundsByPaymentID(%s) = %v, want %v", test.paymentID, transactionIDs, test.transactionIDs)
+				continue
+			}
+			for i := range transactionIDs {
+				if transactionIDs[i] != test.transactionIDs[i] {
+					t.Errorf("RefundsByPaymentID(%s) = %v, want %v", test.paymentID, transactionIDs, test.transactionIDs)
+					break
+				}
+			}
+		}
+
+		refund, err := s.RefundByID("C3")
+		if err != nil || refund.TransactionID != "refund-3" || refund.Status != "pending" {
+			t.Errorf("RefundByID(C3) = %+v, %v, want refund-3 pending", refund, err)
+		}
+
+		if _, err := s.RefundByID("C1"); err != ErrNotFound {
+			t.Errorf("RefundByID(C1) error %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestRecordDelivery(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s OrderStore) {
+		steps := []struct {
+			key string
+
+			duplicate bool
+		}{
+			{"MOJO1:Credit", false},
+			{"MOJO1:Credit", true},
+			{"MOJO1:Failed", false},
+			{"MOJO2:Credit", false},
+			{"MOJO1:Failed", true},
+		}
+
+		for i, step := range steps {
+			delivered, err := s.Delivered(step.key)
+			if err != nil || delivered != step.duplicate {
+				t.Errorf("step %d: Delivered(%s) = %v, %v, want %v", i+1, step.key, delivered, err, step.duplicate)
+			}
+
+			duplicate, err := s.RecordDelivery(step.key)
+			if err != nil || duplicate != step.duplicate {
+				t.Errorf("step %d: RecordDelivery(%s) = %v, %v, want %v", i+1, step.key, duplicate, err, step.duplicate)
+			}
+
+			if delivered, err := s.Delivered(step.key); err != nil || !delivered {
+				t.Errorf("step %d: Delivered(%s) after recording = %v, %v, want true", i+1, step.key, delivered, err)
+			}
+		}
+	})
+}